@@ -0,0 +1,52 @@
+package oauth
+
+import (
+	"context"
+	"log"
+)
+
+// ClaimReference is what an external claim source contributes for one user
+// (OIDC Core 5.6.2). Set JWT for aggregated claims, or Endpoint (plus an
+// optional AccessToken) for distributed claims.
+type ClaimReference struct {
+	JWT         string `json:"JWT,omitempty"`
+	Endpoint    string `json:"endpoint,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+// ClaimResolver fetches or references claims held by an external system.
+type ClaimResolver interface {
+	ResolveClaims(ctx context.Context, subject string) (ClaimReference, error)
+}
+
+// ExternalClaims declares which claims are served by an external source.
+type ExternalClaims struct {
+	Source   string // key in _claim_sources
+	Claims   []string
+	Resolver ClaimResolver
+}
+
+// addExternalClaims adds _claim_names and _claim_sources to userinfo claims
+// for every configured source that resolves for the subject. A failing
+// source is logged and left out rather than failing the request.
+func (s *Server) addExternalClaims(ctx context.Context, subject string, claims map[string]any) {
+	names := map[string]string{}
+	sources := map[string]ClaimReference{}
+
+	for _, external := range s.cfg.ExternalClaims {
+		ref, err := external.Resolver.ResolveClaims(ctx, subject)
+		if err != nil {
+			log.Printf("claim source %s: %v", external.Source, err)
+			continue
+		}
+		sources[external.Source] = ref
+		for _, name := range external.Claims {
+			names[name] = external.Source
+		}
+	}
+
+	if len(sources) > 0 {
+		claims["_claim_names"] = names
+		claims["_claim_sources"] = sources
+	}
+}
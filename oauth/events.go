@@ -0,0 +1,87 @@
+package oauth
+
+import (
+	"log"
+	"time"
+)
+
+// ==========================================
+// Event Bus
+// ==========================================
+
+// Event types published on the Server's event bus.
+const (
+	EventAuthorize    = "authorize" // an authorization code was issued
+	EventTokenIssued  = "token.issued"
+	EventTokenRevoked = "token.revoked"
+	EventAuthFailure  = "auth.failure" // a client or user failed to authenticate
+)
+
+// Event describes something that happened on the Server. Fields that don't
+// apply to an event type are left empty.
+type Event struct {
+	Type     string
+	ClientID string
+	Subject  string
+	Scope    string
+	Reason   string // EventAuthFailure: which check failed, e.g. "invalid_client"
+	RemoteIP string
+	Time     time.Time
+}
+
+// subscriberQueueSize bounds the events buffered for one subscriber.
+const subscriberQueueSize = 100
+
+type subscriber struct {
+	eventType string
+	events    chan Event
+}
+
+// Subscribe calls handler for every event of eventType. Each subscriber has
+// its own buffered queue and worker goroutine, so handlers never run on the
+// request path and a slow one can't stall token issuance or other
+// subscribers; when its queue is full, further events for it are dropped.
+func (s *Server) Subscribe(eventType string, handler func(Event)) {
+	sub := subscriber{eventType: eventType, events: make(chan Event, subscriberQueueSize)}
+	go func() {
+		for event := range sub.events {
+			handler(event)
+		}
+	}()
+
+	s.subscribersMu.Lock()
+	s.subscribers = append(s.subscribers, sub)
+	s.subscribersMu.Unlock()
+}
+
+// publish hands event to the matching subscribers without ever blocking.
+func (s *Server) publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	s.subscribersMu.RLock()
+	defer s.subscribersMu.RUnlock()
+
+	for _, sub := range s.subscribers {
+		if sub.eventType != event.Type {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("subscriber queue full, dropping %s event", event.Type)
+		}
+	}
+}
+
+// tokenEvent builds the event published for an issued or revoked token.
+func tokenEvent(eventType string, accessToken AccessToken) Event {
+	return Event{
+		Type:     eventType,
+		ClientID: accessToken.ClientID,
+		Subject:  accessToken.Subject,
+		Scope:    accessToken.Scope,
+		RemoteIP: accessToken.ClientIP,
+	}
+}
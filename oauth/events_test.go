@@ -0,0 +1,42 @@
+package oauth
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSlowSubscriberDoesNotStallTokens(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled atomic.Int32
+	srv.Subscribe(EventTokenIssued, func(Event) {
+		if handled.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+	})
+
+	issueToken(t, ts)
+	<-started
+	for i := 0; i < subscriberQueueSize; i++ {
+		srv.publish(Event{Type: EventTokenIssued})
+	}
+
+	// The handler is stuck and its queue is full: tokens are still issued,
+	// and their events dropped.
+	for i := 0; i < 3; i++ {
+		issueToken(t, ts)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for handled.Load() < 1+subscriberQueueSize && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := handled.Load(); got != 1+subscriberQueueSize {
+		t.Errorf("handled %d events, want %d: the running one and a full queue", got, 1+subscriberQueueSize)
+	}
+}
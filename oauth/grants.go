@@ -0,0 +1,108 @@
+package oauth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// Additional Grants
+// ==========================================
+
+// grantJWTBearer implements the JWT bearer authorization grant
+// (RFC 7523 section 2.1): a trusted issuer's signed assertion authorizes a
+// token for its subject.
+func (s *Server) grantJWTBearer(w http.ResponseWriter, r *http.Request, client Client) {
+	assertion := r.FormValue("assertion")
+
+	_, unverified, err := parseJWT(assertion)
+	if err != nil {
+		oauthError(w, "invalid_grant", "malformed assertion", http.StatusBadRequest)
+		return
+	}
+	key, trusted := s.cfg.TrustedIssuers[unverified.Issuer]
+	if !trusted {
+		oauthError(w, "invalid_grant", "assertion issuer is not trusted", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := verifyJWT(assertion, key, s.cfg.JWTBearerAlgs)
+	if err != nil {
+		oauthError(w, "invalid_grant", "assertion signature is invalid", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if claims.Subject == "" || claims.ID == "" {
+		oauthError(w, "invalid_grant", "assertion must carry sub and jti", http.StatusBadRequest)
+		return
+	}
+	if issuer := s.issuer(r); !claims.hasAudience(issuer, issuer+"/token") {
+		oauthError(w, "invalid_grant", "assertion audience does not match", http.StatusBadRequest)
+		return
+	}
+	if err := claims.validateTimes(now); err != nil {
+		oauthError(w, "invalid_grant", "assertion "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.useJTI(claims.Issuer, claims.ID, time.Unix(claims.ExpiresAt, 0)) {
+		oauthError(w, "invalid_grant", "assertion was already used", http.StatusBadRequest)
+		return
+	}
+
+	// The assertion's scope claim bounds what may be requested
+	scope := r.FormValue("scope")
+	switch {
+	case scope == "" && claims.Scope != "":
+		scope = claims.Scope
+	case scope == "":
+		scope = s.defaultScope(client)
+	case claims.Scope != "" && !scopeSubset(scope, claims.Scope):
+		jsonError(w, "invalid_scope", http.StatusBadRequest)
+		return
+	}
+	scope, err = s.grantScope(r.Context(), client, claims.Subject, scope, GrantTypeJWTBearer)
+	if err != nil {
+		oauthError(w, "invalid_scope", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := s.issueAccessToken(r, client, claims.Subject, scope)
+	if err != nil {
+		log.Printf("issuing access token: %v", err)
+		jsonError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	writeTokenResponse(w, accessToken)
+}
+
+// grantGuest implements GrantTypeGuest: a short-lived token for an
+// anonymous subject, restricted to Config.GuestScopes.
+func (s *Server) grantGuest(w http.ResponseWriter, r *http.Request, client Client) {
+	guestScopes := strings.Join(s.cfg.GuestScopes, " ")
+
+	scope := r.FormValue("scope")
+	if scope == "" {
+		scope = guestScopes
+	}
+	if scope == "" || !scopeSubset(scope, guestScopes) {
+		jsonError(w, "invalid_scope", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkScopeGrantTypes(scope, GrantTypeGuest); err != nil {
+		oauthError(w, "invalid_scope", err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := s.newAccessToken(r, client, guestSubject, scope, s.cfg.GuestTokenTTL)
+	if err != nil {
+		log.Printf("issuing guest token: %v", err)
+		jsonError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	accessToken.Guest = true
+	s.storeAccessToken(accessToken)
+	writeTokenResponse(w, accessToken)
+}
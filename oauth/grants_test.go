@@ -0,0 +1,82 @@
+package oauth
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func guestRequest(scope string) url.Values {
+	form := secretPost(testClientID, testSecret)
+	form.Set("grant_type", GrantTypeGuest)
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	return form
+}
+
+func TestGuestGrantDisabledByDefault(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	resp, body := postForm(t, ts, "/token", guestRequest(""), nil)
+	if resp.StatusCode != http.StatusBadRequest || body["error"] != "unsupported_grant_type" {
+		t.Fatalf("status = %d, body %v; want unsupported_grant_type", resp.StatusCode, body)
+	}
+}
+
+func TestGuestTokenIsReadOnly(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.EnableGuestGrant = true })
+
+	for _, scope := range []string{"write", "read write", "admin"} {
+		resp, body := postForm(t, ts, "/token", guestRequest(scope), nil)
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_scope" {
+			t.Errorf("scope %q: status = %d, body %v; want invalid_scope", scope, resp.StatusCode, body)
+		}
+	}
+
+	resp, body := postForm(t, ts, "/token", guestRequest(""), nil)
+	if resp.StatusCode != http.StatusOK || body["scope"] != "read" {
+		t.Fatalf("status = %d, body %v; want a read token", resp.StatusCode, body)
+	}
+	if expiresIn, _ := body["expires_in"].(float64); expiresIn <= 0 || expiresIn > 300 {
+		t.Errorf("expires_in = %v, want at most GuestTokenTTL", body["expires_in"])
+	}
+	token := body["access_token"].(string)
+
+	if info := introspectAsDemo(t, ts, token); info["sub"] != guestSubject || info["scope"] != "read" {
+		t.Errorf("introspection = %v, want sub %q with scope read", info, guestSubject)
+	}
+
+	// The reduced profile has nothing but the sub
+	resp, body = get(t, ts, "/userinfo", bearer(token))
+	if resp.StatusCode != http.StatusOK || len(body) != 1 || body["sub"] != guestSubject {
+		t.Errorf("userinfo: status = %d, body %v; want only sub %q", resp.StatusCode, body, guestSubject)
+	}
+}
+
+func TestScopeGrantTypeRestrictions(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) {
+		cfg.EnableGuestGrant = true
+		cfg.GuestScopes = []string{"read", "offline_access", "machine"}
+		cfg.ScopeGrantTypes = map[string][]string{
+			"offline_access": {"authorization_code"},
+			"machine":        {GrantTypeGuest},
+		}
+	})
+
+	if params := redirectParams(t, authorize(t, ts, url.Values{"scope": {"read machine"}})); params.Get("error") != "invalid_scope" {
+		t.Errorf("machine via authorization_code: authorize = %v, want invalid_scope", params)
+	}
+	resp, body := exchange(t, ts, authorizeCode(t, ts, url.Values{"scope": {"read offline_access"}}), nil)
+	if resp.StatusCode != http.StatusOK || body["scope"] != "read offline_access" {
+		t.Errorf("offline_access via authorization_code: status = %d, body %v", resp.StatusCode, body)
+	}
+
+	resp, body = postForm(t, ts, "/token", guestRequest("offline_access"), nil)
+	if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_scope" {
+		t.Errorf("offline_access via guest: status = %d, body %v; want invalid_scope", resp.StatusCode, body)
+	}
+	resp, body = postForm(t, ts, "/token", guestRequest("machine"), nil)
+	if resp.StatusCode != http.StatusOK || body["scope"] != "machine" {
+		t.Errorf("machine via guest: status = %d, body %v", resp.StatusCode, body)
+	}
+}
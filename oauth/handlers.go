@@ -0,0 +1,835 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// 1. Authorization Endpoint
+// Role: Authorization Server
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	// RFC 6749 3.1: GET, or POST with form-encoded parameters, which keeps
+	// them out of browser history and URL length limits.
+	query := r.URL.Query()
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if !parseForm(w, r) {
+			return
+		}
+		// r.Form holds both the body and the URL query, so a parameter
+		// split across the two counts as a duplicate below.
+		query = r.Form
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// RFC 6749 3.1: parameters must not be included more than once
+	for _, param := range []string{"client_id", "redirect_uri", "response_type", "response_mode", "code_challenge"} {
+		if len(query[param]) > 1 {
+			s.authorizeError(w, r, "invalid_request", "duplicate "+param+" parameter")
+			return
+		}
+	}
+
+	if s.cfg.StrictParams {
+		if param := unknownParam(query, authorizeParams); param != "" {
+			s.authorizeError(w, r, "invalid_request", "unexpected parameter "+param)
+			return
+		}
+	}
+
+	// Validation
+	s.mu.Lock()
+	client, exists := s.clientStore[query.Get("client_id")]
+	s.mu.Unlock()
+
+	if !exists {
+		s.authorizeError(w, r, "invalid_request", "unknown client_id")
+		return
+	}
+	if client.Disabled {
+		s.authorizeError(w, r, "unauthorized_client", "client is disabled")
+		return
+	}
+	if query.Get("redirect_uri") != client.RedirectURI {
+		s.authorizeError(w, r, "invalid_request", "redirect_uri does not match the registered one")
+		return
+	}
+	if err := s.validateRedirectURI(client.RedirectURI); err != nil {
+		s.authorizeError(w, r, "invalid_request", err.Error())
+		return
+	}
+
+	// From here on the redirect_uri is trusted, so errors go back to the
+	// client in the query or, for response_mode=fragment, the fragment.
+	responseMode := query.Get("response_mode")
+	if responseMode == "" {
+		responseMode = "query"
+	}
+	if responseMode != "query" && responseMode != "fragment" {
+		s.authorizeError(w, r, "invalid_request", "unsupported response_mode")
+		return
+	}
+	state := query.Get("state")
+	redirectError := func(errCode string, description string) {
+		params := url.Values{"error": {errCode}, "error_description": {description}}
+		if state != "" {
+			params.Set("state", state)
+		}
+		http.Redirect(w, r, authorizeRedirect(client.RedirectURI, responseMode, params), http.StatusFound)
+	}
+
+	if query.Get("response_type") != "code" {
+		redirectError("unsupported_response_type", "only response_type=code is supported")
+		return
+	}
+
+	if len(state) < s.cfg.MinStateLength {
+		redirectError("invalid_request", fmt.Sprintf("state must be at least %d characters; "+
+			"an unguessable state is what protects the client against CSRF (RFC 6749 10.12)", s.cfg.MinStateLength))
+		return
+	}
+
+	// PKCE Check
+	challenge := query.Get("code_challenge")
+	method := query.Get("code_challenge_method")
+	if method == "" {
+		method = "plain" // RFC 7636 4.3 default
+	}
+	if challenge == "" && s.pkceOptional(client.RedirectURI) {
+		log.Printf("WARNING: PKCE skipped for client %q: redirect_uri %q is in InsecurePKCEOptionalRedirectURIs; never enable this in production",
+			client.ID, client.RedirectURI)
+		method = ""
+	} else if challenge == "" || !(method == "S256" || method == "plain" && s.cfg.AllowPlainPKCE ||
+		(method == "S384" || method == "S512") && s.cfg.AllowNonStandardPKCEMethods) {
+		redirectError("invalid_request", "PKCE required (code_challenge + S256)")
+		return
+	} else if method == "plain" && s.cfg.RequireS256ForPublicClients && client.TokenEndpointAuthMethod == AuthMethodNone {
+		redirectError("invalid_request", "public clients must use code_challenge_method=S256")
+		return
+	} else if !challengeWellFormed(method, challenge) {
+		redirectError("invalid_request", "malformed code_challenge for "+method)
+		return
+	}
+	switch method {
+	case "S256":
+		s.metrics.authorizeS256.Add(1)
+	case "plain":
+		s.metrics.authorizePlain.Add(1)
+	}
+
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = s.defaultScope(client)
+	}
+
+	// --- SIMULATE USER LOGIN SCREEN HERE ---
+	// In a real app, a HTML form asking for username/password.
+	// Here we ask AuthenticateUser who is logged in and assume they clicked "Approve".
+	subject, ok := s.authenticateUser(r)
+	if !ok {
+		s.publish(Event{Type: EventAuthFailure, ClientID: client.ID, Reason: "login_required", RemoteIP: remoteIP(r)})
+		redirectError("login_required", "no authenticated user")
+		return
+	}
+
+	scope, err := s.grantScope(r.Context(), client, subject, scope, "authorization_code")
+	if err != nil {
+		redirectError("invalid_scope", err.Error())
+		return
+	}
+
+	// B2B clients may ask for a token scoped to one of the user's
+	// organizations; either parameter name is accepted.
+	organization := query.Get("organization")
+	if orgID := query.Get("org_id"); orgID != "" {
+		if organization != "" && organization != orgID {
+			redirectError("invalid_request", "organization and org_id differ")
+			return
+		}
+		organization = orgID
+	}
+	if organization != "" {
+		if _, member := s.membership(subject, organization); !member {
+			redirectError("access_denied", "user is not a member of the requested organization")
+			return
+		}
+	}
+
+	// Generate Authorization Code
+	code, err := s.newCode()
+	if err != nil {
+		log.Printf("generating authorization code: %v", err)
+		redirectError("server_error", "could not generate an authorization code")
+		return
+	}
+
+	s.mu.Lock()
+	if !s.makeRoomForCode(subject) {
+		s.mu.Unlock()
+		redirectError("temporarily_unavailable", "too many pending authorization requests for this user")
+		return
+	}
+	s.storeCode(AuthCode{
+		Code:                code,
+		ClientID:            client.ID,
+		RedirectURI:         client.RedirectURI,
+		PKCE:                challenge != "",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: method,
+		Subject:             subject,
+		Scope:               scope,
+		Organization:        organization,
+		ExpiresAt:           time.Now().Add(s.cfg.AuthCodeTTL),
+	})
+	s.mu.Unlock()
+
+	s.publish(Event{Type: EventAuthorize, ClientID: client.ID, Subject: subject, Scope: scope, RemoteIP: remoteIP(r)})
+
+	// Redirect back to client with code and state
+	params := url.Values{"code": {code}, "state": {state}}
+
+	if client.CodeCallbackURI != "" {
+		if err := s.deliverCode(client, code, state); err != nil {
+			log.Printf("code callback for %s failed, falling back to redirect: %v", client.ID, err)
+		} else if client.CodeCallbackOnly {
+			params.Del("code")
+		}
+	}
+
+	http.Redirect(w, r, authorizeRedirect(client.RedirectURI, responseMode, params), http.StatusFound)
+}
+
+// authorizeError reports an authorize error that can't be redirected
+// because the client or redirect_uri isn't trusted (RFC 6749 4.1.2.1):
+// an HTML page (error.html) for browsers, JSON for everyone else.
+func (s *Server) authorizeError(w http.ResponseWriter, r *http.Request, errCode string, description string) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.render(w, http.StatusBadRequest, "error.html", map[string]string{
+			"Error":       errCode,
+			"Description": description,
+		})
+		return
+	}
+	oauthError(w, errCode, description, http.StatusBadRequest)
+}
+
+// 2. Token Endpoint
+// Role: Authorization Server
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	// Every rejection from the token endpoint is an RFC 6749 5.2 JSON error
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		oauthError(w, "invalid_request", "the token endpoint only accepts POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Any other body would parse as an empty form and fail much later
+	// with a misleading error, e.g. unsupported_grant_type for JSON.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		oauthError(w, "invalid_request", "Content-Type must be application/x-www-form-urlencoded", http.StatusBadRequest)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	if s.cfg.StrictParams {
+		if param := unknownParam(r.Form, tokenParams); param != "" {
+			oauthError(w, "invalid_request", "unexpected parameter "+param, http.StatusBadRequest)
+			return
+		}
+	}
+
+	grantType := r.FormValue("grant_type")
+	code := r.FormValue("code")
+	verifier := r.FormValue("code_verifier")
+
+	if grantType != "authorization_code" && !(grantType == GrantTypeJWTBearer && s.cfg.EnableJWTBearerGrant) &&
+		!(grantType == GrantTypeGuest && s.cfg.EnableGuestGrant) {
+		jsonError(w, "unsupported_grant_type", http.StatusBadRequest)
+		return
+	}
+
+	if limit, limited := s.cfg.GrantRateLimits[grantType]; limited {
+		if ok, retryAfter := s.grantLimiter.allow(grantType+"|"+remoteIP(r), limit); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			oauthError(w, "temporarily_unavailable", "too many "+grantType+" requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		s.publish(Event{Type: EventAuthFailure, ClientID: presentedClientID(r), Reason: "invalid_client", RemoteIP: remoteIP(r)})
+		jsonError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	if client.Disabled {
+		oauthError(w, "unauthorized_client", "client is disabled", http.StatusBadRequest)
+		return
+	}
+	clientID := client.ID
+
+	if grantType == GrantTypeJWTBearer {
+		s.grantJWTBearer(w, r, client)
+		return
+	}
+	if grantType == GrantTypeGuest {
+		s.grantGuest(w, r, client)
+		return
+	}
+
+	s.mu.Lock()
+	authCode, exists := s.dropCode(code)
+	s.mu.Unlock()
+
+	if !exists {
+		jsonError(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	if time.Now().After(authCode.ExpiresAt) {
+		jsonError(w, "code_expired", http.StatusBadRequest)
+		return
+	}
+	if authCode.ClientID != clientID {
+		jsonError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+
+	// PKCE Verification
+	// S256: code_challenge = BASE64URL-ENCODE(SHA256(ASCII(code_verifier)))
+	// The method recorded at authorize time is authoritative; a method sent
+	// to the token endpoint is ignored so S256 can't be downgraded to plain.
+	// Whether PKCE was used is fixed at authorize time: a code issued
+	// without it (development bypass) must not be redeemed with a verifier,
+	// and one issued with it always needs one, so neither side can be
+	// downgraded unnoticed.
+	switch {
+	case !authCode.PKCE && verifier != "":
+		oauthError(w, "invalid_request", "code_verifier sent for a code issued without PKCE", http.StatusBadRequest)
+		return
+	case authCode.PKCE && verifier == "":
+		oauthError(w, "invalid_request", "code_verifier required", http.StatusBadRequest)
+		return
+	case authCode.PKCE && !verifyPKCE(authCode.CodeChallengeMethod, authCode.CodeChallenge, verifier):
+		s.metrics.pkceVerifyFailure.Add(1)
+		s.publish(Event{Type: EventAuthFailure, ClientID: client.ID, Subject: authCode.Subject, Reason: "pkce", RemoteIP: remoteIP(r)})
+		jsonError(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	// A scope sent again here (not part of the standard code exchange) may
+	// only narrow what was granted at authorize, never widen it.
+	scope := authCode.Scope
+	if requested := r.FormValue("scope"); requested != "" {
+		if !scopeSubset(requested, authCode.Scope) {
+			oauthError(w, "invalid_scope", "scope exceeds the scope granted at authorization", http.StatusBadRequest)
+			return
+		}
+		scope = requested
+	}
+
+	// Grant Access Token
+	accessToken, err := s.newAccessToken(r, client, authCode.Subject, scope, s.cfg.AccessTokenTTL)
+	if err != nil {
+		log.Printf("issuing access token: %v", err)
+		jsonError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	accessToken.Organization = authCode.Organization
+	s.storeAccessToken(accessToken)
+
+	// Return JSON Response
+	writeTokenResponse(w, accessToken)
+}
+
+// 3. Protected Resource Endpoint
+// Role: Resource Server (e.g., Snap Store)
+func (s *Server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	// OIDC Core 5.3.1: GET with a Bearer header, or POST which may instead
+	// carry the token in an access_token form field (RFC 6750 2.2).
+	if r.Method != "GET" && r.Method != "POST" {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if r.Method == "POST" {
+		if !parseForm(w, r) {
+			return
+		}
+		if formToken := r.PostForm.Get("access_token"); formToken != "" {
+			if ok {
+				s.bearerChallenge(w, r, "invalid_request")
+				http.Error(w, "Token sent in both header and body", http.StatusBadRequest)
+				return
+			}
+			token, ok = formToken, true
+		}
+	}
+	var mac *macAuthorization
+	if !ok && s.cfg.EnableMACTokens {
+		if mac, ok = parseMACAuthorization(r); ok {
+			token = mac.ID
+		}
+	}
+	if !ok {
+		s.bearerChallenge(w, r, "")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := s.ValidateToken(token)
+	if err != nil {
+		s.bearerChallenge(w, r, "invalid_token")
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	// A MAC token is only good with a valid signature, never as a bearer
+	// token, and a bearer token can't be presented as a MAC one.
+	if (accessToken.MACKey != "") != (mac != nil) || mac != nil && !s.verifyMAC(r, mac, accessToken) {
+		s.bearerChallenge(w, r, "invalid_token")
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	if !s.bindingMatches(accessToken, r) {
+		s.bearerChallenge(w, r, "invalid_token")
+		http.Error(w, "Token used from a different client", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	client := s.clientStore[accessToken.ClientID]
+	s.mu.Unlock()
+
+	// Guests have no profile: the reduced userinfo is just the sub
+	if accessToken.Guest {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"sub": guestSubject})
+		return
+	}
+
+	subject := accessToken.Subject
+	claims := map[string]any{
+		"sub": s.subjectFor(client, subject),
+	}
+	if s.cfg.Profiles != nil {
+		if profile, ok := s.cfg.Profiles.Profile(subject); ok {
+			name, lang := localizedName(profile, r.Header.Get("Accept-Language"))
+			if lang != "" {
+				w.Header().Set("Content-Language", lang)
+			}
+			claims["name"] = name
+			claims["email"] = profile.Email
+			claims["role"] = profile.Role
+			claims["data"] = profile.Data
+		}
+	}
+	if accessToken.Organization != "" {
+		claims["org_id"] = accessToken.Organization
+		if membership, ok := s.membership(subject, accessToken.Organization); ok {
+			claims["org_name"] = membership.Name
+			claims["role"] = membership.Role
+		}
+	}
+	s.addExternalClaims(r.Context(), subject, claims)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+// 4. Client Secret Rotation Endpoint
+// Role: Authorization Server
+// The client authenticates with its current secret and receives a new one.
+// The previous secret, still valid elsewhere during the overlap, can't
+// rotate: whoever holds a leaked old secret must not lock the owner out.
+func (s *Server) handleRotateSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok || client.TokenEndpointAuthMethod == AuthMethodNone || client.TokenEndpointAuthMethod == AuthMethodPrivateKeyJWT ||
+		!secretMatches(client.Secret, presentedSecret(r)) {
+		jsonError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	if client.Disabled {
+		oauthError(w, "unauthorized_client", "client is disabled", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := s.rotateClientSecret(client.ID)
+	if err != nil {
+		jsonError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"client_id":                  client.ID,
+		"client_secret":              secret,
+		"previous_secret_expires_in": int(s.cfg.SecretRotationOverlap.Seconds()),
+	})
+}
+
+// 5. Token Handoff Endpoints (Backend-for-Frontend)
+// Role: Authorization Server
+// The backend trades its access token for a short-lived, single-use
+// reference that the frontend can pass along and redeem exactly once.
+func (s *Server) handleHandoff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		jsonError(w, "invalid_token", http.StatusUnauthorized)
+		return
+	}
+	if _, err := s.ValidateToken(token); err != nil {
+		jsonError(w, "invalid_token", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.NewRandomFromReader(s.random())
+	if err != nil {
+		jsonError(w, "server_error", http.StatusInternalServerError)
+		return
+	}
+	reference := id.String()
+	now := time.Now()
+
+	s.mu.Lock()
+	s.handoffStore[reference] = Handoff{
+		Reference: reference,
+		Token:     token,
+		ExpiresAt: now.Add(s.cfg.HandoffTTL),
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"handoff_code": reference,
+		"expires_in":   int(s.cfg.HandoffTTL.Seconds()),
+	})
+}
+
+func (s *Server) handleRedeem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	// Single use: the reference is gone as soon as it is looked up
+	reference := r.FormValue("handoff_code")
+
+	s.mu.Lock()
+	handoff, exists := s.handoffStore[reference]
+	delete(s.handoffStore, reference)
+	s.mu.Unlock()
+
+	if !exists || time.Now().After(handoff.ExpiresAt) {
+		jsonError(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := s.ValidateToken(handoff.Token)
+	if err != nil {
+		jsonError(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	body := map[string]any{
+		"access_token": accessToken.Token,
+		"expires_in":   int(time.Until(accessToken.ExpiresAt).Seconds()),
+		"scope":        accessToken.Scope,
+	}
+	setTokenType(body, *accessToken)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// 6. Admin: Revoke All Tokens of a Client
+// Role: Authorization Server
+// For compromised client credentials. Returns how many tokens were revoked.
+func (s *Server) handleRevokeClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdmin(r) {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+
+	clientID := r.FormValue("client_id")
+	if clientID == "" {
+		oauthError(w, "invalid_request", "client_id is required", http.StatusBadRequest)
+		return
+	}
+
+	revoked := s.revokeClientTokens(clientID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"client_id": clientID,
+		"revoked":   revoked,
+	})
+}
+
+// 7. Admin: Disable / Enable a Client
+// Role: Authorization Server
+func (s *Server) handleSetClientDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.isAdmin(r) {
+			jsonError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !parseForm(w, r) {
+			return
+		}
+
+		clientID := r.FormValue("client_id")
+		if err := s.SetClientDisabled(clientID, disabled); err != nil {
+			oauthError(w, "invalid_request", err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"client_id": clientID,
+			"disabled":  disabled,
+		})
+	}
+}
+
+// 8. Admin: Maintenance Mode
+// Role: Authorization Server
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdmin(r) {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		oauthError(w, "invalid_request", "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+	s.SetMaintenance(enabled)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"maintenance": enabled,
+	})
+}
+
+// 9. Token Metadata Endpoint
+// Role: Authorization Server
+// A lightweight introspection: a client may look up the remaining lifetime
+// of its own tokens, and only its own.
+func (s *Server) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	client, ok := s.authenticateClient(r)
+	if !ok {
+		jsonError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	if client.Disabled {
+		oauthError(w, "unauthorized_client", "client is disabled", http.StatusBadRequest)
+		return
+	}
+
+	// Unknown, expired and foreign tokens look the same to the caller
+	accessToken, err := s.ValidateToken(r.PostForm.Get("token"))
+	if err != nil || accessToken.ClientID != client.ID {
+		jsonError(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"expires_in": int(time.Until(accessToken.ExpiresAt).Seconds()),
+		"issued_at":  accessToken.IssuedAt.Unix(),
+		"scope":      accessToken.Scope,
+	})
+}
+
+// 10. Debug: PKCE Checker (Config.EnablePKCEDebug)
+// Lets client developers check a challenge/verifier pair without running
+// the whole flow. Nothing is stored and no token is issued.
+func (s *Server) handlePKCEDebug(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	challenge := r.Form.Get("code_challenge")
+	method := r.Form.Get("code_challenge_method")
+	if method == "" {
+		method = "plain" // RFC 7636 4.3 default
+	}
+	verifier := r.Form.Get("code_verifier")
+
+	computed, ok := pkceChallenge(method, verifier)
+	if !ok {
+		oauthError(w, "invalid_request", "unknown code_challenge_method", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"match":                 verifyPKCE(method, challenge, verifier),
+		"code_challenge_method": method,
+		"computed_challenge":    computed,
+	})
+}
+
+// 11. Admin: Store Stats
+// Role: Authorization Server
+// Entry counts per store and when the janitor last swept them, to spot
+// leaks such as codes piling up because clients never exchange them.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	stats := map[string]any{
+		"clients":         len(s.clientStore),
+		"codes":           len(s.codeStore),
+		"access_tokens":   len(s.tokenStore),
+		"handoffs":        len(s.handoffStore),
+		"used_jtis":       len(s.usedJTIs),
+		"mac_nonces":      len(s.macNonces),
+		"janitor_enabled": s.cfg.JanitorInterval > 0,
+	}
+	if !s.janitorLastRun.IsZero() {
+		stats["janitor_last_run"] = s.janitorLastRun.UTC().Format(time.RFC3339)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// 12. Admin: Revoke Tokens Issued Before a Cutoff
+// Role: Authorization Server
+// The breach kill switch: before is an RFC 3339 timestamp, defaulting to
+// now, and every token issued earlier stops validating.
+func (s *Server) handleRevokeBefore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAdmin(r) {
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !parseForm(w, r) {
+		return
+	}
+
+	cutoff := time.Now()
+	if before := r.FormValue("before"); before != "" {
+		var err error
+		if cutoff, err = time.Parse(time.RFC3339, before); err != nil {
+			oauthError(w, "invalid_request", "before must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		// A future cutoff would also reject every token issued until then
+		if cutoff.After(time.Now()) {
+			oauthError(w, "invalid_request", "before must not be in the future", http.StatusBadRequest)
+			return
+		}
+	}
+	s.RevokeTokensIssuedBefore(cutoff)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"revoked_before": cutoff.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// Health check, always served (including during maintenance)
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+	})
+}
+
+// Catch-all for unknown routes: JSON for API clients, HTML for browsers
+func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.render(w, http.StatusNotFound, "not_found.html", nil)
+		return
+	}
+	jsonError(w, "not_found", http.StatusNotFound)
+}
+
+// Helper: Callback handler (just to show the code in browser)
+func (s *Server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	state := r.URL.Query().Get("state")
+
+	s.render(w, http.StatusOK, "callback.html", map[string]string{
+		"Code":             code,
+		"State":            state,
+		"Error":            r.URL.Query().Get("error"),
+		"ErrorDescription": r.URL.Query().Get("error_description"),
+	})
+}
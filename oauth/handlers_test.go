@@ -0,0 +1,1242 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "demo-client"
+	testSecret      = "demo-secret"
+	testRedirectURI = "http://localhost:8080/cb"
+	testSubject     = "user_123"
+
+	// RFC 7636 appendix B
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// newTestServer serves a Server with the demo client registered and every
+// authorize request made by testSubject. configure may adjust the config.
+func newTestServer(t *testing.T, configure func(*Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.JanitorInterval = 0
+	cfg.AuthenticateUser = func(*http.Request) (string, bool) { return testSubject, true }
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	srv := NewServer(cfg)
+	srv.RegisterClient(Client{ID: testClientID, RedirectURI: testRedirectURI, Secret: testSecret})
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+// noRedirect stops at the authorize redirect so its Location can be read.
+var noRedirect = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// authorizeQuery is a valid S256 authorize request for the demo client.
+// Values in extra replace the defaults; a nil value removes the parameter.
+func authorizeQuery(extra url.Values) url.Values {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz123"},
+		"code_challenge":        {testChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	for name, values := range extra {
+		if values == nil {
+			query.Del(name)
+		} else {
+			query[name] = values
+		}
+	}
+	return query
+}
+
+func authorize(t *testing.T, ts *httptest.Server, extra url.Values) *http.Response {
+	t.Helper()
+	resp, err := noRedirect.Get(ts.URL + "/authorize?" + authorizeQuery(extra).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// redirectParams returns the parameters of an authorize redirect, from the
+// query or, for response_mode=fragment, the fragment.
+func redirectParams(t *testing.T, resp *http.Response) url.Values {
+	t.Helper()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want 302", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Fragment != "" {
+		params, err := url.ParseQuery(location.Fragment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return params
+	}
+	return location.Query()
+}
+
+func authorizeCode(t *testing.T, ts *httptest.Server, extra url.Values) string {
+	t.Helper()
+	params := redirectParams(t, authorize(t, ts, extra))
+	code := params.Get("code")
+	if code == "" {
+		t.Fatalf("authorize returned no code: %v", params)
+	}
+	return code
+}
+
+// postForm POSTs form to path and returns the response with its body
+// decoded as JSON (nil when it isn't JSON).
+func postForm(t *testing.T, ts *httptest.Server, path string, form url.Values, header map[string]string) (*http.Response, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	return do(t, req)
+}
+
+func get(t *testing.T, ts *httptest.Server, path string, header map[string]string) (*http.Response, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest("GET", ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	return do(t, req)
+}
+
+func do(t *testing.T, req *http.Request) (*http.Response, map[string]any) {
+	t.Helper()
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	if json.Unmarshal(raw, &body) != nil {
+		body = nil
+	}
+	return resp, body
+}
+
+// exchange redeems code for the demo client with the RFC 7636 verifier.
+// Values in extra replace the defaults; a nil value removes the parameter.
+func exchange(t *testing.T, ts *httptest.Server, code string, extra url.Values) (*http.Response, map[string]any) {
+	t.Helper()
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+		"client_id":     {testClientID},
+		"client_secret": {testSecret},
+	}
+	for name, values := range extra {
+		if values == nil {
+			form.Del(name)
+		} else {
+			form[name] = values
+		}
+	}
+	return postForm(t, ts, "/token", form, nil)
+}
+
+// issueToken runs the whole flow and returns the access token.
+func issueToken(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	resp, body := exchange(t, ts, authorizeCode(t, ts, nil), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+	}
+	return body["access_token"].(string)
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func TestTokenExpiresInFollowsTTL(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.AccessTokenTTL = 5 * time.Minute })
+
+	resp, body := exchange(t, ts, authorizeCode(t, ts, nil), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body %v", resp.StatusCode, body)
+	}
+	if body["expires_in"] != float64(300) {
+		t.Errorf("expires_in = %v, want 300", body["expires_in"])
+	}
+}
+
+// FuzzHandleToken feeds arbitrary requests to the token endpoint: it must
+// never panic, and every rejection must be a JSON error body.
+func FuzzHandleToken(f *testing.F) {
+	f.Add(uint8(0), "application/x-www-form-urlencoded", "grant_type=authorization_code&code=abc&code_verifier=x", "")
+	f.Add(uint8(0), "application/x-www-form-urlencoded", "grant_type=%zz", "Basic ZGVtby1jbGllbnQ6ZGVtby1zZWNyZXQ=")
+	f.Add(uint8(0), "application/x-www-form-urlencoded; charset=utf-8", "client_assertion_type=urn:ietf:params:oauth:client-assertion-type:jwt-bearer&client_assertion=a.b.c", "")
+	f.Add(uint8(0), "application/json", `{"grant_type":"authorization_code"}`, "")
+	f.Add(uint8(1), "", "", "Bearer x")
+	f.Add(uint8(0), "application/x-www-form-urlencoded", "grant_type=urn:ietf:params:oauth:grant-type:jwt-bearer&assertion=..&client_id=demo-client&client_secret=demo-secret", "")
+
+	methods := []string{"POST", "GET", "PUT", "DELETE", "HEAD"}
+	cfg := DefaultConfig()
+	cfg.JanitorInterval = 0
+	srv := NewServer(cfg)
+	srv.RegisterClient(Client{ID: testClientID, RedirectURI: testRedirectURI, Secret: testSecret})
+	handler := srv.Handler()
+
+	f.Fuzz(func(t *testing.T, method uint8, contentType string, body string, authorization string) {
+		req := httptest.NewRequest(methods[int(method)%len(methods)], "/token", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code < 400 || req.Method == "HEAD" {
+			return
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("status %d with Content-Type %q, body %q", rec.Code, ct, rec.Body.String())
+		}
+		var oauthErr struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &oauthErr); err != nil || oauthErr.Error == "" {
+			t.Fatalf("status %d with body %q, want a JSON error", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+// clientAuthenticates reports whether the token endpoint accepts the
+// credentials: an unknown code is invalid_grant for an authenticated
+// client and invalid_client otherwise.
+func clientAuthenticates(t *testing.T, ts *httptest.Server, form url.Values, header map[string]string) bool {
+	t.Helper()
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", "unknown-code")
+	form.Set("code_verifier", testVerifier)
+	resp, body := postForm(t, ts, "/token", form, header)
+	switch body["error"] {
+	case "invalid_grant":
+		return true
+	case "invalid_client":
+		return false
+	}
+	t.Fatalf("status = %d, body %v; want invalid_grant or invalid_client", resp.StatusCode, body)
+	return false
+}
+
+func secretPost(id string, secret string) url.Values {
+	return url.Values{"client_id": {id}, "client_secret": {secret}}
+}
+
+func TestRotatedSecretsOverlap(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+
+	resp, body := postForm(t, ts, "/client/rotate-secret", secretPost(testClientID, testSecret), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("rotate status = %d, body %v", resp.StatusCode, body)
+	}
+	newSecret := body["client_secret"].(string)
+
+	if !clientAuthenticates(t, ts, secretPost(testClientID, newSecret), nil) {
+		t.Error("new secret rejected")
+	}
+	if !clientAuthenticates(t, ts, secretPost(testClientID, testSecret), nil) {
+		t.Error("previous secret rejected during the overlap")
+	}
+
+	srv.mu.Lock()
+	client := srv.clientStore[testClientID]
+	client.PreviousSecretExpiresAt = time.Now().Add(-time.Second)
+	srv.clientStore[testClientID] = client
+	srv.mu.Unlock()
+
+	if clientAuthenticates(t, ts, secretPost(testClientID, testSecret), nil) {
+		t.Error("previous secret accepted after the overlap")
+	}
+	if !clientAuthenticates(t, ts, secretPost(testClientID, newSecret), nil) {
+		t.Error("new secret rejected after the overlap")
+	}
+}
+
+func TestRotateSecretRequiresCurrentSecret(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	resp, body := postForm(t, ts, "/client/rotate-secret", secretPost(testClientID, testSecret), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("rotate status = %d, body %v", resp.StatusCode, body)
+	}
+	newSecret := body["client_secret"].(string)
+
+	resp, body = postForm(t, ts, "/client/rotate-secret", secretPost(testClientID, testSecret), nil)
+	if resp.StatusCode != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("rotate with the previous secret: status = %d, body %v; want 401 invalid_client", resp.StatusCode, body)
+	}
+	if !clientAuthenticates(t, ts, secretPost(testClientID, newSecret), nil) {
+		t.Error("current secret rejected after a refused rotation")
+	}
+
+	basic := map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(testClientID+":"+newSecret))}
+	if resp, body := postForm(t, ts, "/client/rotate-secret", url.Values{}, basic); resp.StatusCode != http.StatusOK {
+		t.Errorf("rotate with the current secret: status = %d, body %v", resp.StatusCode, body)
+	}
+}
+
+func TestUserInfoBearerSchemeCaseInsensitive(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	token := issueToken(t, ts)
+
+	for _, header := range []string{"bearer " + token, "BEARER " + token, "  Bearer   " + token + "  "} {
+		resp, body := get(t, ts, "/userinfo", map[string]string{"Authorization": header})
+		if resp.StatusCode != http.StatusOK || body["sub"] != testSubject {
+			t.Errorf("Authorization %q: status = %d, body %v", header, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestAuthorizeRejectsDuplicateParams(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	for _, param := range []string{"client_id", "redirect_uri", "response_type", "code_challenge"} {
+		query := authorizeQuery(nil)
+		query.Add(param, query.Get(param))
+		resp, body := get(t, ts, "/authorize?"+query.Encode(), nil)
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_request" {
+			t.Errorf("duplicate %s: status = %d, body %v; want 400 invalid_request", param, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestTokenBinding(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*Config)
+		remoteIP  string
+		userAgent string
+		wantOK    bool
+	}{
+		{"ip match", func(cfg *Config) { cfg.BindTokenToIP = true }, "127.0.0.1", "other-agent", true},
+		{"ip mismatch", func(cfg *Config) { cfg.BindTokenToIP = true }, "10.0.0.9", "Go-http-client/1.1", false},
+		{"user agent match", func(cfg *Config) { cfg.BindTokenToUserAgent = true }, "10.0.0.9", "Go-http-client/1.1", true},
+		{"user agent mismatch", func(cfg *Config) { cfg.BindTokenToUserAgent = true }, "127.0.0.1", "other-agent", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ts := newTestServer(t, tt.configure)
+			token := issueToken(t, ts) // from 127.0.0.1 with Go's default User-Agent
+
+			req := httptest.NewRequest("GET", "/userinfo", nil)
+			req.RemoteAddr = tt.remoteIP + ":4321"
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if ok := rec.Code == http.StatusOK; ok != tt.wantOK {
+				t.Fatalf("status = %d, want ok=%v", rec.Code, tt.wantOK)
+			}
+			if !tt.wantOK && !strings.Contains(rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+				t.Errorf("WWW-Authenticate = %q, want invalid_token", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestPKCEDowngradeToPlainFails(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.AllowPlainPKCE = true })
+
+	// An attacker holding the S256 challenge presents it as a plain
+	// verifier and asks for the plain method at the token endpoint.
+	code := authorizeCode(t, ts, nil)
+	resp, body := exchange(t, ts, code, url.Values{
+		"code_verifier":         {testChallenge},
+		"code_challenge_method": {"plain"},
+	})
+	if resp.StatusCode != http.StatusBadRequest || body["access_token"] != nil {
+		t.Errorf("downgrade: status = %d, body %v; want 400 without a token", resp.StatusCode, body)
+	}
+}
+
+func TestClientAuthenticationMethods(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+	key := newP256Key(t)
+	for _, client := range []Client{
+		{ID: "basic-client", Secret: "basic-secret", TokenEndpointAuthMethod: AuthMethodSecretBasic},
+		{ID: "post-client", Secret: "post-secret", TokenEndpointAuthMethod: AuthMethodSecretPost},
+		{ID: "public-client", TokenEndpointAuthMethod: AuthMethodNone},
+		{ID: "jwt-client", TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT, PublicKey: &key.PublicKey},
+	} {
+		client.RedirectURI = testRedirectURI
+		srv.RegisterClient(client)
+	}
+
+	basic := func(id, secret string) map[string]string {
+		return map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(id+":"+secret))}
+	}
+	assertion := func(jti string) url.Values {
+		return url.Values{
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion": {signES256(t, key, JWTClaims{
+				Issuer: "jwt-client", Subject: "jwt-client", Audience: audience{srv.cfg.Issuer + "/token"},
+				ExpiresAt: time.Now().Add(time.Minute).Unix(), ID: jti,
+			})},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		header map[string]string
+		want   bool
+	}{
+		{"basic via header", url.Values{}, basic("basic-client", "basic-secret"), true},
+		{"basic via form", secretPost("basic-client", "basic-secret"), nil, false},
+		{"post via form", secretPost("post-client", "post-secret"), nil, true},
+		{"post via header", url.Values{}, basic("post-client", "post-secret"), false},
+		{"post wrong secret", secretPost("post-client", "wrong"), nil, false},
+		{"none with client_id only", url.Values{"client_id": {"public-client"}}, nil, true},
+		{"none presenting a secret", secretPost("public-client", "anything"), nil, false},
+		{"unregistered method without secret", url.Values{"client_id": {testClientID}}, nil, false},
+		{"private_key_jwt assertion", assertion("jti-1"), nil, true},
+		{"private_key_jwt plus header", assertion("jti-2"), basic("jwt-client", ""), false},
+		{"secret for jwt client", secretPost("jwt-client", "anything"), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientAuthenticates(t, ts, tt.form, tt.header); got != tt.want {
+				t.Errorf("authenticated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptySecretNeverAuthenticates(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+	for _, method := range []string{"", AuthMethodSecretBasic, AuthMethodSecretPost} {
+		if err := srv.RegisterClient(Client{ID: "secretless", RedirectURI: testRedirectURI, TokenEndpointAuthMethod: method}); err == nil {
+			t.Errorf("method %q: RegisterClient accepted a client without a secret", method)
+		}
+	}
+	if err := srv.ReplaceClients([]Client{{ID: testClientID, RedirectURI: testRedirectURI}}); err == nil {
+		t.Error("ReplaceClients accepted a client without a secret")
+	}
+	if !clientAuthenticates(t, ts, secretPost(testClientID, testSecret), nil) {
+		t.Error("a refused ReplaceClients changed the registry")
+	}
+
+	// A client stored without a secret, bypassing registration, still can't
+	// be authenticated with an empty one.
+	srv.mu.Lock()
+	srv.clientStore["secretless"] = Client{ID: "secretless", RedirectURI: testRedirectURI}
+	srv.mu.Unlock()
+
+	emptyBasic := map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("secretless:"))}
+	if clientAuthenticates(t, ts, url.Values{}, emptyBasic) {
+		t.Error("empty basic secret authenticated")
+	}
+	if clientAuthenticates(t, ts, secretPost("secretless", ""), nil) {
+		t.Error("empty client_secret authenticated")
+	}
+	if resp, body := postForm(t, ts, "/client/rotate-secret", url.Values{}, emptyBasic); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("rotate with an empty secret: status = %d, body %v; want 401", resp.StatusCode, body)
+	}
+}
+
+func TestClientCredentialsInQueryAreIgnored(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	query := secretPost(testClientID, testSecret).Encode()
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {"unknown-code"}, "code_verifier": {testVerifier}}
+	resp, body := postForm(t, ts, "/token?"+query, form, nil)
+	if resp.StatusCode != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Fatalf("secret in query: status = %d, body %v; want 401 invalid_client", resp.StatusCode, body)
+	}
+}
+
+// issueTokenFor is issueToken for another client sharing the demo redirect URI.
+func issueTokenFor(t *testing.T, ts *httptest.Server, clientID string, secret string) string {
+	t.Helper()
+	code := authorizeCode(t, ts, url.Values{"client_id": {clientID}})
+	resp, body := exchange(t, ts, code, secretPost(clientID, secret))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+	}
+	return body["access_token"].(string)
+}
+
+func userinfoSub(t *testing.T, ts *httptest.Server, token string) string {
+	t.Helper()
+	resp, body := get(t, ts, "/userinfo", bearer(token))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("userinfo status = %d, body %v", resp.StatusCode, body)
+	}
+	sub, _ := body["sub"].(string)
+	return sub
+}
+
+func TestPairwiseSubjects(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.PairwiseSalt = "test-salt" })
+	for _, client := range []Client{
+		{ID: "photos", SectorIdentifier: "photos.example.com"},
+		{ID: "photos-mobile", SectorIdentifier: "photos.example.com"},
+		{ID: "printing", SectorIdentifier: "printing.example.com"},
+	} {
+		client.RedirectURI = testRedirectURI
+		client.Secret = client.ID + "-secret"
+		client.SubjectType = SubjectTypePairwise
+		srv.RegisterClient(client)
+	}
+
+	photos := userinfoSub(t, ts, issueTokenFor(t, ts, "photos", "photos-secret"))
+	mobile := userinfoSub(t, ts, issueTokenFor(t, ts, "photos-mobile", "photos-mobile-secret"))
+	printing := userinfoSub(t, ts, issueTokenFor(t, ts, "printing", "printing-secret"))
+	public := userinfoSub(t, ts, issueToken(t, ts))
+
+	if public != testSubject {
+		t.Errorf("public client sub = %q, want %q", public, testSubject)
+	}
+	if photos == testSubject || printing == testSubject {
+		t.Errorf("pairwise sub leaks the user id: %q, %q", photos, printing)
+	}
+	if photos == printing {
+		t.Errorf("clients in different sectors share sub %q", photos)
+	}
+	if photos != mobile {
+		t.Errorf("clients in one sector got %q and %q, want the same sub", photos, mobile)
+	}
+	if again := userinfoSub(t, ts, issueTokenFor(t, ts, "photos", "photos-secret")); again != photos {
+		t.Errorf("sub changed between tokens: %q, then %q", photos, again)
+	}
+}
+
+func TestPairwiseClientsNeedSalt(t *testing.T) {
+	pairwise := Client{ID: "photos", RedirectURI: testRedirectURI, Secret: "photos-secret", SubjectType: SubjectTypePairwise}
+
+	unsalted, _ := newTestServer(t, nil)
+	if err := unsalted.RegisterClient(pairwise); err == nil {
+		t.Error("RegisterClient accepted a pairwise client without PairwiseSalt")
+	}
+	if err := unsalted.ReplaceClients([]Client{pairwise}); err == nil {
+		t.Error("ReplaceClients accepted a pairwise client without PairwiseSalt")
+	}
+
+	// Two servers sharing the salt, as after a restart or across replicas,
+	// give the same user the same sub.
+	var subs []string
+	for i := 0; i < 2; i++ {
+		srv, ts := newTestServer(t, func(cfg *Config) { cfg.PairwiseSalt = "shared-salt" })
+		if err := srv.RegisterClient(pairwise); err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, userinfoSub(t, ts, issueTokenFor(t, ts, "photos", "photos-secret")))
+	}
+	if subs[0] != subs[1] {
+		t.Errorf("servers with one salt gave subs %q and %q", subs[0], subs[1])
+	}
+}
+
+func TestUserInfoMethods(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	token := issueToken(t, ts)
+
+	t.Run("GET with header", func(t *testing.T) {
+		resp, body := get(t, ts, "/userinfo", bearer(token))
+		if resp.StatusCode != http.StatusOK || body["sub"] != testSubject {
+			t.Errorf("status = %d, body %v", resp.StatusCode, body)
+		}
+	})
+	t.Run("POST with header", func(t *testing.T) {
+		resp, body := postForm(t, ts, "/userinfo", url.Values{}, bearer(token))
+		if resp.StatusCode != http.StatusOK || body["sub"] != testSubject {
+			t.Errorf("status = %d, body %v", resp.StatusCode, body)
+		}
+	})
+	t.Run("POST with form field", func(t *testing.T) {
+		resp, body := postForm(t, ts, "/userinfo", url.Values{"access_token": {token}}, nil)
+		if resp.StatusCode != http.StatusOK || body["sub"] != testSubject {
+			t.Errorf("status = %d, body %v", resp.StatusCode, body)
+		}
+	})
+	t.Run("POST with header and form field", func(t *testing.T) {
+		resp, _ := postForm(t, ts, "/userinfo", url.Values{"access_token": {token}}, bearer(token))
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", resp.StatusCode)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); got != `Bearer error="invalid_request"` {
+			t.Errorf("WWW-Authenticate = %q", got)
+		}
+	})
+	t.Run("PUT", func(t *testing.T) {
+		req, err := http.NewRequest("PUT", ts.URL+"/userinfo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, _ := do(t, req)
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, POST" {
+			t.Errorf("status = %d, Allow %q; want 405 with GET, POST", resp.StatusCode, resp.Header.Get("Allow"))
+		}
+	})
+}
+
+func TestPKCEOptionalOnlyForConfiguredRedirectURIs(t *testing.T) {
+	tests := []struct {
+		name        string
+		optional    []string
+		redirectURI string
+		wantSkipped bool
+	}{
+		{"not configured", nil, testRedirectURI, false},
+		{"exact entry", []string{testRedirectURI}, testRedirectURI, true},
+		{"exact entry, other path", []string{testRedirectURI}, "http://localhost:8080/other", false},
+		{"prefix entry", []string{"http://localhost:8080/*"}, "http://localhost:8080/other", true},
+		{"prefix entry, other host", []string{"http://localhost:8080/*"}, "https://app.example.com/cb", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ts := newTestServer(t, func(cfg *Config) { cfg.InsecurePKCEOptionalRedirectURIs = tt.optional })
+			srv.RegisterClient(Client{ID: testClientID, RedirectURI: tt.redirectURI, Secret: testSecret})
+
+			params := redirectParams(t, authorize(t, ts, url.Values{
+				"redirect_uri": {tt.redirectURI}, "code_challenge": nil, "code_challenge_method": nil,
+			}))
+			if !tt.wantSkipped {
+				if params.Get("error") != "invalid_request" {
+					t.Fatalf("authorize without PKCE = %v, want invalid_request", params)
+				}
+				return
+			}
+
+			resp, body := exchange(t, ts, params.Get("code"), url.Values{"redirect_uri": {tt.redirectURI}, "code_verifier": nil})
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestAuthorizeScopePolicy(t *testing.T) {
+	notAdmin := func(_ context.Context, _ Client, _ string, scope string) bool { return scope != "admin" }
+	tests := []struct {
+		name      string
+		scope     []string
+		reject    bool
+		maxScopes int
+		wantErr   string
+		wantScope string
+	}{
+		{name: "narrowed", scope: []string{"read admin"}, wantScope: "read"},
+		{name: "rejected", scope: []string{"read admin"}, reject: true, wantErr: "invalid_scope"},
+		{name: "allowed", scope: []string{"read"}, reject: true, wantScope: "read"},
+		{name: "every scope refused", scope: []string{"admin"}, wantErr: "invalid_scope"},
+		{name: "nothing requested", scope: nil, wantScope: ""},
+		{name: "too many scopes", scope: []string{"read write profile"}, maxScopes: 2, wantErr: "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := newTestServer(t, func(cfg *Config) {
+				cfg.AuthorizeScope = notAdmin
+				cfg.RejectUnauthorizedScopes = tt.reject
+				cfg.MaxScopes = tt.maxScopes
+				cfg.DefaultScope = ""
+			})
+
+			params := redirectParams(t, authorize(t, ts, url.Values{"scope": tt.scope}))
+			if params.Get("error") != tt.wantErr {
+				t.Fatalf("authorize = %v, want error %q", params, tt.wantErr)
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			resp, body := exchange(t, ts, params.Get("code"), nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+			}
+			if scope, _ := body["scope"].(string); scope != tt.wantScope {
+				t.Errorf("granted scope = %q, want %q", scope, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestAuthorizeErrorResponseMode(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	tests := []struct {
+		mode         []string
+		wantFragment bool
+	}{
+		{nil, false},
+		{[]string{"query"}, false},
+		{[]string{"fragment"}, true},
+	}
+	for _, tt := range tests {
+		// Missing PKCE is only detected after redirect_uri is validated
+		resp := authorize(t, ts, url.Values{"response_mode": tt.mode, "code_challenge": nil})
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("response_mode %v: status = %d, want 302", tt.mode, resp.StatusCode)
+		}
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params, other := location.Query(), location.Fragment
+		if tt.wantFragment {
+			params, err = url.ParseQuery(location.Fragment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other = location.RawQuery
+		}
+		if params.Get("error") != "invalid_request" || params.Get("error_description") == "" || params.Get("state") != "xyz123" {
+			t.Errorf("response_mode %v: params = %v, want error, error_description and state", tt.mode, params)
+		}
+		if other != "" {
+			t.Errorf("response_mode %v: Location %q also carries parameters elsewhere", tt.mode, location)
+		}
+	}
+}
+
+func TestTokenRequiresFormContentType(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	code := authorizeCode(t, ts, nil)
+	form := url.Values{
+		"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {testRedirectURI},
+		"code_verifier": {testVerifier}, "client_id": {testClientID}, "client_secret": {testSecret},
+	}
+
+	for _, contentType := range []string{"text/plain", "application/json", ""} {
+		req, err := http.NewRequest("POST", ts.URL+"/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, body := do(t, req)
+		description, _ := body["error_description"].(string)
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_request" ||
+			!strings.Contains(description, "application/x-www-form-urlencoded") {
+			t.Errorf("Content-Type %q: status = %d, body %v; want invalid_request naming the expected type", contentType, resp.StatusCode, body)
+		}
+	}
+
+	// The rejected requests didn't consume the code, and parameters on the
+	// media type are fine
+	header := map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"}
+	if resp, body := postForm(t, ts, "/token", form, header); resp.StatusCode != http.StatusOK {
+		t.Errorf("form request: status = %d, body %v", resp.StatusCode, body)
+	}
+}
+
+func TestAuthorizeRedirectURIPolicy(t *testing.T) {
+	tests := []struct {
+		uri          string
+		wantRedirect bool
+	}{
+		{"https://app.example.com/cb", true},
+		{"https://app.example.com/cb#fragment", false},
+		{"http://app.example.com/cb", false},
+	}
+	for _, tt := range tests {
+		srv, ts := newTestServer(t, nil)
+		srv.RegisterClient(Client{ID: testClientID, RedirectURI: tt.uri, Secret: testSecret})
+
+		resp := authorize(t, ts, url.Values{"redirect_uri": {tt.uri}})
+		// A rejected redirect_uri is never redirected to
+		if redirected := resp.StatusCode == http.StatusFound; redirected != tt.wantRedirect {
+			t.Errorf("%s: status = %d, want redirect %v", tt.uri, resp.StatusCode, tt.wantRedirect)
+		}
+	}
+}
+
+func TestCodeBoundToPKCEPresence(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.InsecurePKCEOptionalRedirectURIs = []string{testRedirectURI} })
+
+	withoutPKCE := url.Values{"code_challenge": nil, "code_challenge_method": nil}
+	tests := []struct {
+		name      string
+		authorize url.Values
+		exchange  url.Values
+		wantError string
+	}{
+		{"PKCE code without verifier", nil, url.Values{"code_verifier": nil}, "code_verifier required"},
+		{"PKCE code with wrong verifier", nil, url.Values{"code_verifier": {testVerifier + "x"}}, ""},
+		{"non-PKCE code with verifier", withoutPKCE, nil, "code_verifier sent for a code issued without PKCE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := exchange(t, ts, authorizeCode(t, ts, tt.authorize), tt.exchange)
+			if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_request" {
+				t.Fatalf("status = %d, body %v; want invalid_request", resp.StatusCode, body)
+			}
+			if tt.wantError != "" && body["error_description"] != tt.wantError {
+				t.Errorf("error_description = %v, want %q", body["error_description"], tt.wantError)
+			}
+		})
+	}
+}
+
+func TestNonStandardPKCEMethods(t *testing.T) {
+	s384 := sha512.Sum384([]byte(testVerifier))
+	s512 := sha512.Sum512([]byte(testVerifier))
+	challenges := map[string]string{
+		"S384": base64.RawURLEncoding.EncodeToString(s384[:]),
+		"S512": base64.RawURLEncoding.EncodeToString(s512[:]),
+	}
+
+	for method, challenge := range challenges {
+		t.Run(method, func(t *testing.T) {
+			extra := url.Values{"code_challenge": {challenge}, "code_challenge_method": {method}}
+
+			_, off := newTestServer(t, nil)
+			if params := redirectParams(t, authorize(t, off, extra)); params.Get("error") != "invalid_request" {
+				t.Errorf("flag off: authorize = %v, want invalid_request", params)
+			}
+
+			_, on := newTestServer(t, func(cfg *Config) { cfg.AllowNonStandardPKCEMethods = true })
+			if resp, body := exchange(t, on, authorizeCode(t, on, extra), nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("flag on: token status = %d, body %v", resp.StatusCode, body)
+			}
+			wrong := url.Values{"code_verifier": {strings.Repeat("a", 43)}}
+			if resp, _ := exchange(t, on, authorizeCode(t, on, extra), wrong); resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("flag on, wrong verifier: token status = %d, want 400", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// getAuthorize requests /authorize with an Accept header, without
+// following redirects, and returns the response with its raw body.
+func getAuthorize(t *testing.T, ts *httptest.Server, query url.Values, accept string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", ts.URL+"/authorize?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(raw)
+}
+
+func TestAuthorizeErrorPage(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.StrictParams = true })
+	// The unexpected parameter name ends up in the description
+	query := authorizeQuery(url.Values{"client_id": {"unknown"}})
+	query.Set("<script>", "1")
+
+	t.Run("browser", func(t *testing.T) {
+		resp, body := getAuthorize(t, ts, query, "text/html,application/xhtml+xml;q=0.9")
+		if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			t.Fatalf("status = %d, Content-Type %q; want a 400 HTML page", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if !strings.Contains(body, "invalid_request") || !strings.Contains(body, "&lt;script&gt;") || strings.Contains(body, "<script>") {
+			t.Errorf("page does not show the escaped error: %s", body)
+		}
+	})
+	t.Run("API client", func(t *testing.T) {
+		resp, body := getAuthorize(t, ts, query, "application/json")
+		var decoded map[string]any
+		if err := json.Unmarshal([]byte(body), &decoded); err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("status = %d, body %q; want a 400 JSON error", resp.StatusCode, body)
+		}
+		if decoded["error"] != "invalid_request" || decoded["error_description"] != "unexpected parameter <script>" {
+			t.Errorf("body = %v", decoded)
+		}
+	})
+}
+
+func TestAuthorizeErrorPageOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "error.html"), []byte(`{{define "error.html"}}custom: {{.Error}}{{end}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.TemplateDir = dir })
+
+	_, body := getAuthorize(t, ts, authorizeQuery(url.Values{"client_id": {"unknown"}}), "text/html")
+	if body != "custom: invalid_request" {
+		t.Errorf("page = %q, want the override", body)
+	}
+}
+
+func TestOrganizationScopedTokens(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) {
+		cfg.Profiles = StaticProfiles{testSubject: {
+			Subject: testSubject,
+			Role:    "admin",
+			Organizations: map[string]Membership{
+				"org_print_magic": {Name: "Print Magic Inc.", Role: "viewer"},
+			},
+		}}
+	})
+
+	for _, param := range []string{"organization", "org_id"} {
+		params := redirectParams(t, authorize(t, ts, url.Values{param: {"org_someone_else"}}))
+		if params.Get("error") != "access_denied" || params.Get("code") != "" {
+			t.Errorf("%s of a non-member: authorize = %v, want access_denied", param, params)
+		}
+	}
+	params := redirectParams(t, authorize(t, ts, url.Values{"organization": {"org_print_magic"}, "org_id": {"org_other"}}))
+	if params.Get("error") != "invalid_request" {
+		t.Errorf("conflicting organization and org_id: authorize = %v, want invalid_request", params)
+	}
+
+	code := authorizeCode(t, ts, url.Values{"org_id": {"org_print_magic"}})
+	resp, body := exchange(t, ts, code, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+	}
+	if info := introspectAsDemo(t, ts, body["access_token"].(string)); info["org_id"] != "org_print_magic" {
+		t.Errorf("introspection = %v, want org_id", info)
+	}
+	_, claims := get(t, ts, "/userinfo", bearer(body["access_token"].(string)))
+	if claims["org_id"] != "org_print_magic" || claims["org_name"] != "Print Magic Inc." || claims["role"] != "viewer" {
+		t.Errorf("userinfo = %v, want the organization's name and role", claims)
+	}
+}
+
+func TestTokenScopeCannotEscalate(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	tests := []struct {
+		scope     string
+		wantError string
+		wantScope string
+	}{
+		{"read write", "invalid_scope", ""},
+		{"admin", "invalid_scope", ""},
+		{"read", "", "read"},
+		{"", "", "read profile"},
+	}
+	for _, tt := range tests {
+		code := authorizeCode(t, ts, url.Values{"scope": {"read profile"}})
+		extra := url.Values{"scope": nil}
+		if tt.scope != "" {
+			extra.Set("scope", tt.scope)
+		}
+		resp, body := exchange(t, ts, code, extra)
+		if tt.wantError != "" {
+			if resp.StatusCode != http.StatusBadRequest || body["error"] != tt.wantError {
+				t.Errorf("scope %q: status = %d, body %v; want %s", tt.scope, resp.StatusCode, body, tt.wantError)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK || body["scope"] != tt.wantScope {
+			t.Errorf("scope %q: status = %d, body %v; want scope %q", tt.scope, resp.StatusCode, body, tt.wantScope)
+		}
+	}
+}
+
+func TestRevokeTokensIssuedBefore(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.AdminToken = "admin-secret" })
+	admin := bearer("admin-secret")
+
+	old := issueToken(t, ts)
+	time.Sleep(time.Millisecond)
+	resp, body := postForm(t, ts, "/admin/revoke-before", url.Values{}, admin)
+	if resp.StatusCode != http.StatusOK || body["revoked_before"] == nil {
+		t.Fatalf("revoke-before status = %d, body %v", resp.StatusCode, body)
+	}
+	fresh := issueToken(t, ts)
+
+	if resp, _ := get(t, ts, "/userinfo", bearer(old)); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token issued before the cutoff: userinfo status = %d, want 401", resp.StatusCode)
+	}
+	if resp, _ := get(t, ts, "/userinfo", bearer(fresh)); resp.StatusCode != http.StatusOK {
+		t.Errorf("token issued after the cutoff: userinfo status = %d, want 200", resp.StatusCode)
+	}
+
+	for _, before := range []string{time.Now().Add(time.Hour).Format(time.RFC3339), "yesterday"} {
+		resp, body := postForm(t, ts, "/admin/revoke-before", url.Values{"before": {before}}, admin)
+		if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_request" {
+			t.Errorf("before=%s: status = %d, body %v; want invalid_request", before, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := get(t, ts, "/userinfo", bearer(fresh)); resp.StatusCode != http.StatusOK {
+		t.Errorf("after a rejected cutoff: userinfo status = %d, want 200", resp.StatusCode)
+	}
+	if resp, _ := postForm(t, ts, "/admin/revoke-before", url.Values{}, bearer("wrong")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without the admin token: status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestRevokeClientTokens(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.AdminToken = "admin-secret" })
+	srv.RegisterClient(Client{ID: "other", RedirectURI: testRedirectURI, Secret: "other-secret"})
+	revoked := []string{issueToken(t, ts), issueToken(t, ts)}
+	kept := issueTokenFor(t, ts, "other", "other-secret")
+	form := url.Values{"client_id": {testClientID}}
+
+	for _, header := range []map[string]string{nil, bearer("wrong")} {
+		if resp, body := postForm(t, ts, "/admin/revoke-client", form, header); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %v: status = %d, body %v; want 401", header, resp.StatusCode, body)
+		}
+	}
+	if resp, body := postForm(t, ts, "/admin/revoke-client", url.Values{}, bearer("admin-secret")); body["error"] != "invalid_request" {
+		t.Errorf("without client_id: status = %d, body %v; want invalid_request", resp.StatusCode, body)
+	}
+
+	resp, body := postForm(t, ts, "/admin/revoke-client", form, bearer("admin-secret"))
+	if resp.StatusCode != http.StatusOK || body["revoked"] != float64(2) {
+		t.Fatalf("revoke-client status = %d, body %v; want 2 revoked", resp.StatusCode, body)
+	}
+	for _, token := range revoked {
+		if resp, _ := get(t, ts, "/userinfo", bearer(token)); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("revoked token: userinfo status = %d, want 401", resp.StatusCode)
+		}
+	}
+	if resp, _ := get(t, ts, "/userinfo", bearer(kept)); resp.StatusCode != http.StatusOK {
+		t.Errorf("other client's token: userinfo status = %d, want 200", resp.StatusCode)
+	}
+	if _, body := postForm(t, ts, "/admin/revoke-client", form, bearer("admin-secret")); body["revoked"] != float64(0) {
+		t.Errorf("second revoke = %v, want 0 revoked", body)
+	}
+
+	// Expired tokens leave the index with the janitor too
+	srv.mu.Lock()
+	accessToken := srv.tokenStore[kept]
+	accessToken.ExpiresAt = time.Now().Add(-time.Second)
+	srv.tokenStore[kept] = accessToken
+	srv.mu.Unlock()
+	srv.sweepExpired()
+	srv.mu.Lock()
+	indexed := len(srv.clientTokens)
+	srv.mu.Unlock()
+	if indexed != 0 {
+		t.Errorf("client index holds %d clients, want 0", indexed)
+	}
+}
+
+func TestDisabledClient(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+		cfg.CheckClientEnabledOnUse = true
+	})
+	srv.RegisterClient(Client{ID: "resource-server", RedirectURI: testRedirectURI, Secret: "rs-secret"})
+	token := issueToken(t, ts)
+	code := authorizeCode(t, ts, nil)
+	_, body := postForm(t, ts, "/token/handoff", url.Values{}, bearer(token))
+	handoff, _ := body["handoff_code"].(string)
+	if handoff == "" {
+		t.Fatalf("handoff = %v", body)
+	}
+	guarded := srv.RequireAudience(srv.cfg.Issuer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	guardedStatus := func() int {
+		req := httptest.NewRequest("GET", "/photos", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		guarded.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status := guardedStatus(); status != http.StatusOK {
+		t.Fatalf("RequireAudience status = %d before disabling, want 200", status)
+	}
+
+	setDisabled := func(path string) {
+		t.Helper()
+		if resp, body := postForm(t, ts, path, url.Values{"client_id": {testClientID}}, bearer("admin-secret")); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s status = %d, body %v", path, resp.StatusCode, body)
+		}
+	}
+	setDisabled("/admin/disable-client")
+
+	credentials := secretPost(testClientID, testSecret)
+	for _, path := range []string{"/client/rotate-secret", "/token/info", "/introspect"} {
+		form := secretPost(testClientID, testSecret)
+		form.Set("token", token)
+		if resp, body := postForm(t, ts, path, form, nil); resp.StatusCode != http.StatusBadRequest || body["error"] != "unauthorized_client" {
+			t.Errorf("%s as the disabled client: status = %d, body %v; want unauthorized_client", path, resp.StatusCode, body)
+		}
+	}
+	if resp, body := exchange(t, ts, code, credentials); body["error"] != "unauthorized_client" {
+		t.Errorf("token: status = %d, body %v; want unauthorized_client", resp.StatusCode, body)
+	}
+
+	if _, err := srv.ValidateToken(token); err != ErrClientDisabled {
+		t.Errorf("ValidateToken error = %v, want ErrClientDisabled", err)
+	}
+	if resp, _ := get(t, ts, "/userinfo", bearer(token)); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("userinfo status = %d, want 401", resp.StatusCode)
+	}
+	introspection := secretPost("resource-server", "rs-secret")
+	introspection.Set("token", token)
+	if _, body := postForm(t, ts, "/introspect", introspection, nil); body["active"] != false {
+		t.Errorf("introspect = %v, want inactive", body)
+	}
+	if resp, _ := postForm(t, ts, "/token/handoff", url.Values{}, bearer(token)); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("handoff status = %d, want 401", resp.StatusCode)
+	}
+	if _, body := postForm(t, ts, "/token/redeem", url.Values{"handoff_code": {handoff}}, nil); body["error"] != "invalid_grant" {
+		t.Errorf("redeem = %v, want invalid_grant", body)
+	}
+	if status := guardedStatus(); status != http.StatusUnauthorized {
+		t.Errorf("RequireAudience status = %d, want 401", status)
+	}
+
+	setDisabled("/admin/enable-client")
+	if resp, _ := get(t, ts, "/userinfo", bearer(token)); resp.StatusCode != http.StatusOK {
+		t.Errorf("after re-enabling: userinfo status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAuthorizeAcceptsPOST(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	post := func(query url.Values, form url.Values) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("POST", ts.URL+"/authorize?"+query.Encode(), strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, _ := do(t, req)
+		return resp
+	}
+
+	params := redirectParams(t, post(nil, authorizeQuery(nil)))
+	if params.Get("code") == "" || params.Get("state") != "xyz123" {
+		t.Fatalf("POST authorize = %v, want a code and the state", params)
+	}
+	if resp, body := exchange(t, ts, params.Get("code"), nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("token status = %d, body %v", resp.StatusCode, body)
+	}
+
+	// A parameter split across the query and the body is a duplicate, even
+	// with the same value
+	for _, query := range []url.Values{{"client_id": {"other-client"}}, {"code_challenge": {testChallenge}}} {
+		if resp := post(query, authorizeQuery(nil)); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v in query and body: status = %d, want 400", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestAuthorizeRejectsMalformedChallenge(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+	tests := []struct {
+		name      string
+		challenge string
+	}{
+		{"too short", testChallenge[:42]},
+		{"too long", testChallenge + "A"},
+		{"standard base64", strings.Replace(testChallenge, "-", "+", 1)},
+		{"padded", testChallenge[:42] + "="},
+		{"not base64", strings.Repeat("!", 43)},
+	}
+	for _, tt := range tests {
+		params := redirectParams(t, authorize(t, ts, url.Values{"code_challenge": {tt.challenge}}))
+		if params.Get("error") != "invalid_request" || !strings.Contains(params.Get("error_description"), "malformed code_challenge") {
+			t.Errorf("%s: authorize = %v, want invalid_request for a malformed code_challenge", tt.name, params)
+		}
+	}
+	if params := redirectParams(t, authorize(t, ts, nil)); params.Get("code") == "" {
+		t.Errorf("well-formed challenge: authorize = %v, want a code", params)
+	}
+}
+
+func TestPendingCodesPerSubject(t *testing.T) {
+	t.Run("evict oldest", func(t *testing.T) {
+		_, ts := newTestServer(t, func(cfg *Config) { cfg.MaxPendingCodesPerSubject = 2 })
+		first := authorizeCode(t, ts, nil)
+		second := authorizeCode(t, ts, nil)
+		third := authorizeCode(t, ts, nil)
+
+		if resp, _ := exchange(t, ts, first, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("evicted code: token status = %d, want 400", resp.StatusCode)
+		}
+		for _, code := range []string{second, third} {
+			if resp, body := exchange(t, ts, code, nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("pending code: token status = %d, body %v", resp.StatusCode, body)
+			}
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		srv, ts := newTestServer(t, func(cfg *Config) {
+			cfg.MaxPendingCodesPerSubject = 2
+			cfg.PendingCodePolicy = PendingCodesReject
+		})
+		first := authorizeCode(t, ts, nil)
+		authorizeCode(t, ts, nil)
+		if params := redirectParams(t, authorize(t, ts, nil)); params.Get("error") != "temporarily_unavailable" {
+			t.Fatalf("over the cap: authorize = %v, want temporarily_unavailable", params)
+		}
+
+		// Redeeming a code frees its slot
+		if resp, body := exchange(t, ts, first, nil); resp.StatusCode != http.StatusOK {
+			t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+		}
+		authorizeCode(t, ts, nil)
+
+		// So does expiry, whether or not the janitor has run yet
+		expireCodes(srv)
+		authorizeCode(t, ts, nil)
+		expireCodes(srv)
+		srv.sweepExpired()
+		srv.mu.Lock()
+		indexed := len(srv.subjectCodes)
+		srv.mu.Unlock()
+		if indexed != 0 {
+			t.Errorf("subject index holds %d subjects after the sweep, want 0", indexed)
+		}
+		authorizeCode(t, ts, nil)
+		authorizeCode(t, ts, nil)
+	})
+}
+
+// expireCodes backdates every pending authorization code.
+func expireCodes(srv *Server) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for code, authCode := range srv.codeStore {
+		authCode.ExpiresAt = time.Now().Add(-time.Second)
+		srv.codeStore[code] = authCode
+	}
+}
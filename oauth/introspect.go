@@ -0,0 +1,235 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================
+// Token Introspection (RFC 7662)
+// ==========================================
+
+// Introspection is the /introspect response. Inactive tokens carry only
+// Active=false, so callers can't tell unknown, expired and revoked apart.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	OrgID     string `json:"org_id,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+}
+
+// handleIntrospect lets an authenticated client (typically a resource
+// server registered as one) look up the metadata behind an opaque token.
+func (s *Server) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !parseForm(w, r) {
+		return
+	}
+
+	// A public client has no secret to prove who it is, so it can't be
+	// trusted with other clients' token metadata.
+	client, ok := s.authenticateClient(r)
+	if !ok || client.TokenEndpointAuthMethod == AuthMethodNone {
+		jsonError(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	if client.Disabled {
+		oauthError(w, "unauthorized_client", "client is disabled", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.introspect(r.PostForm.Get("token"), s.issuer(r)))
+}
+
+func (s *Server) introspect(token string, issuer string) Introspection {
+	accessToken, err := s.ValidateToken(token)
+	if err != nil {
+		return Introspection{Active: false}
+	}
+
+	s.mu.Lock()
+	client := s.clientStore[accessToken.ClientID]
+	s.mu.Unlock()
+
+	tokenType := "Bearer"
+	if accessToken.MACKey != "" {
+		tokenType = "mac"
+	}
+
+	return Introspection{
+		Active:    true,
+		Scope:     accessToken.Scope,
+		ClientID:  accessToken.ClientID,
+		Subject:   s.subjectFor(client, accessToken.Subject),
+		Audience:  accessToken.Audience,
+		OrgID:     accessToken.Organization,
+		Region:    accessToken.Region,
+		Issuer:    issuer,
+		TokenType: tokenType,
+		ExpiresAt: accessToken.ExpiresAt.Unix(),
+		IssuedAt:  accessToken.IssuedAt.Unix(),
+		NotBefore: accessToken.NotBefore.Unix(),
+	}
+}
+
+// IntrospectionClient validates tokens for a resource server by calling a
+// remote /introspect endpoint, authenticating with client_secret_basic.
+// Active results are cached until the token's exp, or for at most
+// MaxCacheTTL; inactive results are cached for NegativeCacheTTL.
+type IntrospectionClient struct {
+	Endpoint     string // e.g. "http://localhost:8080/introspect"
+	ClientID     string
+	ClientSecret string
+	HTTPClient   *http.Client // defaults to http.DefaultClient
+
+	// Bounds how long an active result is trusted, so a revoked token is
+	// noticed within MaxCacheTTL. Zero caches until exp.
+	MaxCacheTTL time.Duration
+	// How long an inactive result is cached. Zero disables negative caching.
+	NegativeCacheTTL time.Duration
+	// Upper bound on cached tokens; zero means defaultIntrospectionCacheSize.
+	MaxCacheEntries int
+
+	mu    sync.Mutex
+	cache map[string]cachedIntrospection
+}
+
+type cachedIntrospection struct {
+	result    Introspection
+	expiresAt time.Time
+}
+
+const defaultIntrospectionCacheSize = 10000
+
+// NewIntrospectionValidator returns an IntrospectionClient for endpoint that
+// re-checks active tokens at least every minute and remembers inactive ones
+// for five seconds.
+func NewIntrospectionValidator(endpoint, clientID, clientSecret string) *IntrospectionClient {
+	return &IntrospectionClient{
+		Endpoint:         endpoint,
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		MaxCacheTTL:      time.Minute,
+		NegativeCacheTTL: 5 * time.Second,
+	}
+}
+
+// ValidateToken introspects token and returns ErrInvalidToken unless it is
+// active.
+func (c *IntrospectionClient) ValidateToken(ctx context.Context, token string) (*Introspection, error) {
+	result, err := c.IntrospectToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Active {
+		return nil, ErrInvalidToken
+	}
+	return result, nil
+}
+
+// IntrospectToken returns the introspection result for token, from the
+// cache when an earlier result is still fresh.
+func (c *IntrospectionClient) IntrospectToken(ctx context.Context, token string) (*Introspection, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	cached, ok := c.cache[token]
+	if ok && !now.Before(cached.expiresAt) {
+		delete(c.cache, token)
+		ok = false
+	}
+	c.mu.Unlock()
+	if ok {
+		result := cached.result
+		return &result, nil
+	}
+
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.ClientID, c.ClientSecret)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint answered %s", resp.Status)
+	}
+	var result Introspection
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var expiresAt time.Time
+	if result.Active {
+		expiresAt = time.Unix(result.ExpiresAt, 0)
+		if c.MaxCacheTTL > 0 && now.Add(c.MaxCacheTTL).Before(expiresAt) {
+			expiresAt = now.Add(c.MaxCacheTTL)
+		}
+	} else {
+		expiresAt = now.Add(c.NegativeCacheTTL)
+	}
+	if now.Before(expiresAt) {
+		c.store(token, cachedIntrospection{result: result, expiresAt: expiresAt}, now)
+	}
+	return &result, nil
+}
+
+// store caches entry under token. When the cache is full, expired entries
+// are dropped first and, failing that, an arbitrary one is evicted.
+func (c *IntrospectionClient) store(token string, entry cachedIntrospection, now time.Time) {
+	limit := c.MaxCacheEntries
+	if limit <= 0 {
+		limit = defaultIntrospectionCacheSize
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cache == nil {
+		c.cache = make(map[string]cachedIntrospection)
+	}
+	if _, exists := c.cache[token]; !exists && len(c.cache) >= limit {
+		for t, cached := range c.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(c.cache, t)
+			}
+		}
+		for t := range c.cache {
+			if len(c.cache) < limit {
+				break
+			}
+			delete(c.cache, t)
+		}
+	}
+	c.cache[token] = entry
+}
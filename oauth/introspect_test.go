@@ -0,0 +1,58 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// introspectAsDemo asks /introspect about token, authenticating as the demo client.
+func introspectAsDemo(t *testing.T, ts *httptest.Server, token string) map[string]any {
+	t.Helper()
+	form := secretPost(testClientID, testSecret)
+	form.Set("token", token)
+	resp, body := postForm(t, ts, "/introspect", form, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("introspect status = %d, body %v", resp.StatusCode, body)
+	}
+	return body
+}
+
+func TestDefaultAudience(t *testing.T) {
+	tests := []struct {
+		name           string
+		configured     string
+		clientAudience string
+		want           string
+	}{
+		{"falls back to the issuer", "", "", "http://localhost:8080"},
+		{"server default", "https://api.example.com", "", "https://api.example.com"},
+		{"client default wins", "https://api.example.com", "https://billing.example.com", "https://billing.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ts := newTestServer(t, func(cfg *Config) { cfg.DefaultAudience = tt.configured })
+			srv.RegisterClient(Client{ID: testClientID, RedirectURI: testRedirectURI, Secret: testSecret, DefaultAudience: tt.clientAudience})
+
+			body := introspectAsDemo(t, ts, issueToken(t, ts))
+			if body["active"] != true || body["aud"] != tt.want {
+				t.Errorf("introspection = %v, want active with aud %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectRejectsPublicClients(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+	srv.RegisterClient(Client{ID: "public-client", RedirectURI: testRedirectURI, TokenEndpointAuthMethod: AuthMethodNone})
+	token := issueToken(t, ts)
+
+	resp, body := postForm(t, ts, "/introspect", url.Values{"client_id": {"public-client"}, "token": {token}}, nil)
+	if resp.StatusCode != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Fatalf("public client: status = %d, body %v; want 401 invalid_client", resp.StatusCode, body)
+	}
+	if body := introspectAsDemo(t, ts, token); body["active"] != true {
+		t.Errorf("confidential client: introspection = %v, want active", body)
+	}
+}
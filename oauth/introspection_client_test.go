@@ -0,0 +1,166 @@
+package oauth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oauth2-example/oauth"
+	"oauth2-example/oauth/testsupport"
+)
+
+var (
+	demoClient     = oauth.Client{ID: "demo-client", RedirectURI: "http://localhost:8080/cb", Secret: "demo-secret"}
+	resourceServer = oauth.Client{ID: "resource-server", RedirectURI: "http://localhost:9090/cb", Secret: "rs-secret"}
+)
+
+// countingTransport counts the requests it sends.
+type countingTransport struct {
+	calls atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newIntrospectionClient(t *testing.T) (*oauth.Server, *oauth.IntrospectionClient, *countingTransport, string) {
+	t.Helper()
+	cfg := oauth.DefaultConfig()
+	cfg.JanitorInterval = 0
+	srv, ts := testsupport.NewServer(cfg, "user_123", demoClient, resourceServer)
+	t.Cleanup(ts.Close)
+
+	transport := &countingTransport{}
+	client := oauth.NewIntrospectionValidator(ts.URL+"/introspect", resourceServer.ID, resourceServer.Secret)
+	client.HTTPClient = &http.Client{Transport: transport}
+	return srv, client, transport, ts.URL
+}
+
+func TestIntrospectionClientCachesActiveTokens(t *testing.T) {
+	srv, client, transport, baseURL := newIntrospectionClient(t)
+	client.MaxCacheTTL = 100 * time.Millisecond
+	ctx := context.Background()
+
+	token, err := testsupport.AuthorizationCode(ctx, baseURL, testsupport.Options{Client: demoClient, Scope: "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := client.ValidateToken(ctx, token.AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateToken #%d: %v", i+1, err)
+		}
+		if result.Subject != "user_123" || result.Scope != "read" {
+			t.Errorf("result = %+v", result)
+		}
+	}
+	if calls := transport.calls.Load(); calls != 1 {
+		t.Fatalf("introspection calls within the TTL = %d, want 1", calls)
+	}
+
+	// Revoked meanwhile: the cached result hides it until it expires
+	srv.RevokeTokensIssuedBefore(time.Now())
+	if _, err := client.ValidateToken(ctx, token.AccessToken); err != nil {
+		t.Fatalf("ValidateToken within the TTL: %v, want the cached result", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := client.ValidateToken(ctx, token.AccessToken); !errors.Is(err, oauth.ErrInvalidToken) {
+		t.Fatalf("ValidateToken after the TTL = %v, want ErrInvalidToken", err)
+	}
+	if calls := transport.calls.Load(); calls != 2 {
+		t.Errorf("introspection calls = %d, want 2", calls)
+	}
+}
+
+func TestIntrospectionClientCachesInactiveTokensBriefly(t *testing.T) {
+	_, client, transport, _ := newIntrospectionClient(t)
+	client.NegativeCacheTTL = 100 * time.Millisecond
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ValidateToken(ctx, "unknown-token"); !errors.Is(err, oauth.ErrInvalidToken) {
+			t.Fatalf("ValidateToken #%d = %v, want ErrInvalidToken", i+1, err)
+		}
+	}
+	if calls := transport.calls.Load(); calls != 1 {
+		t.Fatalf("introspection calls within the negative TTL = %d, want 1", calls)
+	}
+	time.Sleep(150 * time.Millisecond)
+	client.ValidateToken(ctx, "unknown-token")
+	if calls := transport.calls.Load(); calls != 2 {
+		t.Errorf("introspection calls after the negative TTL = %d, want 2", calls)
+	}
+}
+
+func TestIntrospectionClientCacheIsBounded(t *testing.T) {
+	_, client, transport, _ := newIntrospectionClient(t)
+	client.MaxCacheEntries = 2
+	ctx := context.Background()
+
+	for _, token := range []string{"a", "b", "c"} {
+		client.ValidateToken(ctx, token)
+	}
+	// At most two of the three are still cached
+	for _, token := range []string{"a", "b", "c"} {
+		client.ValidateToken(ctx, token)
+	}
+	if calls := transport.calls.Load(); calls < 4 {
+		t.Errorf("introspection calls = %d, want at least 4 with room for two entries", calls)
+	}
+}
+
+func TestIntrospectionClientRequireAudience(t *testing.T) {
+	cfg := oauth.DefaultConfig()
+	cfg.JanitorInterval = 0
+	cfg.DefaultAudience = "https://photos.example.com"
+	billing := oauth.Client{ID: "billing", RedirectURI: "http://localhost:8080/cb", Secret: "billing-secret", DefaultAudience: "https://billing.example.com"}
+	_, ts := testsupport.NewServer(cfg, "user_123", demoClient, resourceServer, billing)
+	defer ts.Close()
+	ctx := context.Background()
+
+	photosToken, err := testsupport.AuthorizationCode(ctx, ts.URL, testsupport.Options{Client: demoClient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	billingToken, err := testsupport.AuthorizationCode(ctx, ts.URL, testsupport.Options{Client: billing})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guard := func(endpoint string) http.Handler {
+		client := oauth.NewIntrospectionValidator(endpoint, resourceServer.ID, resourceServer.Secret)
+		return client.RequireAudience("https://photos.example.com")(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+	}
+	tests := []struct {
+		name       string
+		endpoint   string
+		token      string
+		wantStatus int
+	}{
+		{"accepted audience", ts.URL + "/introspect", photosToken.AccessToken, http.StatusNoContent},
+		{"other audience", ts.URL + "/introspect", billingToken.AccessToken, http.StatusUnauthorized},
+		{"inactive token", ts.URL + "/introspect", "nope", http.StatusUnauthorized},
+		{"no token", ts.URL + "/introspect", "", http.StatusUnauthorized},
+		{"introspection unavailable", ts.URL + "/missing", photosToken.AccessToken, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/photos", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			guard(tt.endpoint).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"time"
+)
+
+// ==========================================
+// Janitor
+// ==========================================
+
+// runJanitor removes expired codes, tokens, handoff references and replay
+// records every Config.JanitorInterval, so entries clients never come back
+// for don't accumulate.
+func (s *Server) runJanitor() {
+	ticker := time.NewTicker(s.cfg.JanitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sweepExpired()
+	}
+}
+
+func (s *Server) sweepExpired() {
+	now := time.Now()
+
+	s.mu.Lock()
+	for code, authCode := range s.codeStore {
+		if now.After(authCode.ExpiresAt) {
+			s.dropCode(code)
+		}
+	}
+	for token, accessToken := range s.tokenStore {
+		if now.After(accessToken.ExpiresAt) {
+			s.dropToken(token)
+		}
+	}
+	for reference, handoff := range s.handoffStore {
+		if now.After(handoff.ExpiresAt) {
+			delete(s.handoffStore, reference)
+		}
+	}
+	for key, exp := range s.usedJTIs {
+		if now.After(exp) {
+			delete(s.usedJTIs, key)
+		}
+	}
+	for key, exp := range s.macNonces {
+		if now.After(exp) {
+			delete(s.macNonces, key)
+		}
+	}
+	s.janitorLastRun = now
+	s.mu.Unlock()
+}
@@ -0,0 +1,142 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// JWT Verification
+// ==========================================
+
+// JWTClaims are the registered claims this server reads from incoming JWTs
+// (client assertions and similar).
+type JWTClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti"`
+	Scope     string   `json:"scope,omitempty"`
+}
+
+// audience accepts both the single-string and array forms of "aud".
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+var errInvalidJWT = errors.New("invalid JWT")
+
+// parseJWT splits a compact JWS and decodes its header and claims without
+// verifying anything.
+func parseJWT(token string) (header map[string]any, claims JWTClaims, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, claims, errInvalidJWT
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(rawHeader, &header) != nil {
+		return nil, claims, errInvalidJWT
+	}
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(rawClaims, &claims) != nil {
+		return nil, claims, errInvalidJWT
+	}
+	return header, claims, nil
+}
+
+// verifyJWT checks the signature of a compact JWS against key. The token's
+// "alg" header must be in allowedAlgs (never "none") and must match the key
+// type, so an attacker can't pick an algorithm of their own, e.g. HS256
+// keyed with the RSA public key.
+func verifyJWT(token string, key crypto.PublicKey, allowedAlgs []string) (JWTClaims, error) {
+	header, claims, err := parseJWT(token)
+	if err != nil {
+		return claims, err
+	}
+
+	alg, _ := header["alg"].(string)
+	if alg == "none" || !slices.Contains(allowedAlgs, alg) {
+		return claims, fmt.Errorf("alg %q is not allowed", alg)
+	}
+
+	parts := strings.Split(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errInvalidJWT
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return claims, errors.New("RS256 requires an RSA key")
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return claims, errors.New("bad signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return claims, errors.New("ES256 requires a P-256 key")
+		}
+		if len(signature) != 64 {
+			return claims, errors.New("bad signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return claims, errors.New("bad signature")
+		}
+	default:
+		return claims, fmt.Errorf("unsupported alg %q", alg)
+	}
+	return claims, nil
+}
+
+// validateTimes checks exp (required) and nbf against now.
+func (c JWTClaims) validateTimes(now time.Time) error {
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0)) {
+		return errors.New("expired or missing exp")
+	}
+	if c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("not yet valid")
+	}
+	return nil
+}
+
+func (c JWTClaims) hasAudience(accepted ...string) bool {
+	for _, aud := range c.Audience {
+		if slices.Contains(accepted, aud) {
+			return true
+		}
+	}
+	return false
+}
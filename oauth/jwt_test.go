@@ -0,0 +1,143 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signES256 builds a compact JWS over claims with an ES256 signature.
+func signES256(t *testing.T, key *ecdsa.PrivateKey, claims any) string {
+	t.Helper()
+	return signWith(t, "ES256", claims, func(signingInput string) []byte {
+		digest := sha256.Sum256([]byte(signingInput))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	})
+}
+
+// signWith builds a compact JWS with any alg header, including ones no
+// honest signer would use.
+func signWith(t *testing.T, alg string, claims any, sign func(signingInput string) []byte) string {
+	t.Helper()
+	signingInput := encodeSegment(t, map[string]string{"alg": alg, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(signingInput))
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func newP256Key(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifyJWTPinsAlgorithms(t *testing.T) {
+	ecKey := newP256Key(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := JWTClaims{Issuer: "jwt-client", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+	rs256 := func(signingInput string) []byte {
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	// The classic confusion attack: HMAC keyed with the public key, which
+	// the attacker knows, hoping the verifier treats the key as a secret.
+	confused := func(signingInput string) []byte {
+		mac := hmac.New(sha256.New, rsaPublicDER)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil)
+	}
+	unsigned := func(string) []byte { return nil }
+
+	tests := []struct {
+		name    string
+		token   string
+		key     crypto.PublicKey
+		allowed []string
+		wantErr bool
+	}{
+		{"valid RS256", signWith(t, "RS256", claims, rs256), &rsaKey.PublicKey, []string{"RS256", "ES256"}, false},
+		{"valid ES256", signES256(t, ecKey, claims), &ecKey.PublicKey, []string{"RS256", "ES256"}, false},
+		{"alg none", signWith(t, "none", claims, unsigned), &rsaKey.PublicKey, []string{"RS256", "ES256"}, true},
+		{"alg none even if allowed", signWith(t, "none", claims, unsigned), &rsaKey.PublicKey, []string{"none"}, true},
+		{"HS256 keyed with the public key", signWith(t, "HS256", claims, confused), &rsaKey.PublicKey, []string{"RS256", "ES256"}, true},
+		{"HS256 keyed with the public key, allowed", signWith(t, "HS256", claims, confused), &rsaKey.PublicKey, []string{"HS256"}, true},
+		{"RS256 header on an EC key", signWith(t, "RS256", claims, rs256), &ecKey.PublicKey, []string{"RS256", "ES256"}, true},
+		{"ES256 header on an RSA key", signES256(t, ecKey, claims), &rsaKey.PublicKey, []string{"RS256", "ES256"}, true},
+		{"alg outside the allowlist", signES256(t, ecKey, claims), &ecKey.PublicKey, []string{"RS256"}, true},
+		{"tampered claims", tamper(t, signES256(t, ecKey, claims)), &ecKey.PublicKey, []string{"ES256"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(tt.token, tt.key, tt.allowed)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("verifyJWT error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper swaps the claims of a signed token, keeping its signature.
+func tamper(t *testing.T, token string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	parts[1] = encodeSegment(t, JWTClaims{Issuer: "someone-else", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	return strings.Join(parts, ".")
+}
+
+func TestClientAssertionRejectsAlgNone(t *testing.T) {
+	srv, ts := newTestServer(t, nil)
+	key := newP256Key(t)
+	srv.RegisterClient(Client{ID: "jwt-client", RedirectURI: testRedirectURI, TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT, PublicKey: &key.PublicKey})
+
+	claims := JWTClaims{
+		Issuer: "jwt-client", Subject: "jwt-client", Audience: audience{srv.cfg.Issuer + "/token"},
+		ExpiresAt: time.Now().Add(time.Minute).Unix(), ID: "jti-none",
+	}
+	form := url.Values{
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {signWith(t, "none", claims, func(string) []byte { return nil })},
+	}
+	if clientAuthenticates(t, ts, form, nil) {
+		t.Fatal("unsigned client assertion authenticated")
+	}
+}
@@ -0,0 +1,86 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// macHeader signs a GET /userinfo to ts per draft-ietf-oauth-v2-http-mac.
+func macHeader(t *testing.T, ts *httptest.Server, token string, key string, timestamp time.Time, nonce string) string {
+	t.Helper()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	normalized := fmt.Sprintf("%s\n%s\nGET\n/userinfo\n%s\n%s\n\n", unix, nonce, u.Hostname(), u.Port())
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(normalized))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return fmt.Sprintf(`MAC id="%s", ts="%s", nonce="%s", mac="%s"`, token, unix, nonce, signature)
+}
+
+func TestMACTokens(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.EnableMACTokens = true })
+	srv.RegisterClient(Client{ID: testClientID, RedirectURI: testRedirectURI, Secret: testSecret, MACTokens: true})
+
+	resp, body := exchange(t, ts, authorizeCode(t, ts, nil), nil)
+	if resp.StatusCode != http.StatusOK || body["token_type"] != "mac" || body["mac_key"] == nil {
+		t.Fatalf("token status = %d, body %v; want a MAC token", resp.StatusCode, body)
+	}
+	token, key := body["access_token"].(string), body["mac_key"].(string)
+	userinfo := func(authorization string) int {
+		resp, _ := get(t, ts, "/userinfo", map[string]string{"Authorization": authorization})
+		return resp.StatusCode
+	}
+
+	now := time.Now()
+	signed := macHeader(t, ts, token, key, now, "nonce-1")
+	if status := userinfo(signed); status != http.StatusOK {
+		t.Fatalf("signed request: status = %d, want 200", status)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{"replayed nonce", signed},
+		{"wrong key", macHeader(t, ts, token, "wrong-key", now, "nonce-2")},
+		{"stale timestamp", macHeader(t, ts, token, key, now.Add(-time.Hour), "nonce-3")},
+		{"as a bearer token", "Bearer " + token},
+	}
+	for _, tt := range tests {
+		if status := userinfo(tt.authorization); status != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", tt.name, status)
+		}
+	}
+	if status := userinfo(macHeader(t, ts, token, key, now, "nonce-4")); status != http.StatusOK {
+		t.Errorf("fresh nonce: status = %d, want 200", status)
+	}
+
+	// Nonces live apart from assertion jtis and are swept by the janitor
+	srv.mu.Lock()
+	nonces, jtis := len(srv.macNonces), len(srv.usedJTIs)
+	for key := range srv.macNonces {
+		srv.macNonces[key] = time.Now().Add(-time.Second)
+	}
+	srv.mu.Unlock()
+	if nonces != 2 || jtis != 0 {
+		t.Errorf("recorded %d nonces and %d jtis, want 2 and 0", nonces, jtis)
+	}
+	srv.sweepExpired()
+	srv.mu.Lock()
+	nonces = len(srv.macNonces)
+	srv.mu.Unlock()
+	if nonces != 0 {
+		t.Errorf("%d nonces left after the sweep, want 0", nonces)
+	}
+}
@@ -0,0 +1,28 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// ==========================================
+// Metrics
+// ==========================================
+
+// metrics are simple counters kept per Server and served as JSON at
+// /metrics for security monitoring.
+type metrics struct {
+	authorizeS256     atomic.Int64
+	authorizePlain    atomic.Int64
+	pkceVerifyFailure atomic.Int64
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"authorize_pkce_s256_total":    s.metrics.authorizeS256.Load(),
+		"authorize_pkce_plain_total":   s.metrics.authorizePlain.Load(),
+		"token_pkce_verify_fail_total": s.metrics.pkceVerifyFailure.Load(),
+	})
+}
@@ -0,0 +1,158 @@
+package oauth
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ==========================================
+// Middleware
+// ==========================================
+
+// limitBody caps request bodies at Config.MaxBodyBytes so ParseForm can't
+// be made to buffer arbitrarily large payloads.
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.MaxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unlessMaintenance short-circuits the handler with 503 while maintenance
+// mode is on.
+func (s *Server) unlessMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.maintenance.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.cfg.MaintenanceRetryAfter.Seconds())))
+			oauthError(w, "temporarily_unavailable", "the server is in maintenance mode", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// gzipResponse compresses the handler's response when the client accepts
+// gzip and the body is at least Config.GzipMinBytes, so small responses
+// aren't compressed for nothing. The response is buffered to decide.
+func (s *Server) gzipResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if s.cfg.GzipMinBytes <= 0 || !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+
+		buffered := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next(buffered, r)
+
+		if buffered.body.Len() < s.cfg.GzipMinBytes {
+			w.WriteHeader(buffered.status)
+			w.Write(buffered.body.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(buffered.status)
+		gz := gzip.NewWriter(w)
+		gz.Write(buffered.body.Bytes())
+		gz.Close()
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// bufferedResponse collects a response so it can be inspected before being sent.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// problemError is an RFC 7807 problem document. The OAuth error and
+// error_description are kept as extension members so OAuth clients can
+// still read them.
+type problemError struct {
+	Type             string `json:"type"`
+	Title            string `json:"title"`
+	Status           int    `json:"status"`
+	Detail           string `json:"detail,omitempty"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// problemResponse rewrites OAuth JSON error bodies as
+// application/problem+json when Config.ProblemJSON is set or the client
+// asks for it in Accept.
+func (s *Server) problemResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.ProblemJSON && !strings.Contains(r.Header.Get("Accept"), "application/problem+json") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buffered := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buffered, r)
+
+		var oauthErr struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if buffered.status < 400 || w.Header().Get("Content-Type") != "application/json" ||
+			w.Header().Get("Content-Encoding") != "" ||
+			json.Unmarshal(buffered.body.Bytes(), &oauthErr) != nil || oauthErr.Error == "" {
+			w.WriteHeader(buffered.status)
+			w.Write(buffered.body.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(buffered.status)
+		json.NewEncoder(w).Encode(problemError{
+			Type:             "https://www.rfc-editor.org/rfc/rfc6749#section-5.2",
+			Title:            oauthErr.Error,
+			Status:           buffered.status,
+			Detail:           oauthErr.ErrorDescription,
+			Error:            oauthErr.Error,
+			ErrorDescription: oauthErr.ErrorDescription,
+		})
+	})
+}
+
+// parseForm parses the request form and writes the error response itself
+// when that fails, as an RFC 6749 5.2 invalid_request: 413 for bodies over
+// the limit, 400 otherwise.
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseForm()
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		oauthError(w, "invalid_request", "request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	oauthError(w, "invalid_request", "malformed form body", http.StatusBadRequest)
+	return false
+}
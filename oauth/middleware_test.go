@@ -0,0 +1,155 @@
+package oauth
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.MaxBodyBytes = 1024 })
+
+	for _, path := range []string{"/token", "/introspect", "/token/info"} {
+		resp, body := postRaw(t, ts.URL+path, "grant_type=authorization_code&code="+strings.Repeat("a", 4096))
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status = %d, want 413", path, resp.StatusCode)
+		}
+		if body["error"] != "invalid_request" {
+			t.Errorf("%s: body = %v, want an invalid_request JSON error", path, body)
+		}
+	}
+}
+
+func TestMalformedFormIsJSONError(t *testing.T) {
+	_, ts := newTestServer(t, nil)
+
+	resp, body := postRaw(t, ts.URL+"/token", "grant_type=%zz")
+	if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_request" {
+		t.Errorf("status = %d, body %v; want 400 invalid_request", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+}
+
+// postRaw POSTs an already encoded form body.
+func postRaw(t *testing.T, url string, body string) (*http.Response, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(t, req)
+}
+
+func TestUserInfoGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		minBytes int
+		want     string
+	}{
+		{"over the threshold", 1, "gzip"},
+		{"under the threshold", 1 << 20, ""},
+		{"disabled", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := newTestServer(t, func(cfg *Config) { cfg.GzipMinBytes = tt.minBytes })
+			token := issueToken(t, ts)
+
+			req, err := http.NewRequest("GET", ts.URL+"/userinfo", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if got := resp.Header.Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			var body io.Reader = resp.Body
+			if tt.want == "gzip" {
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			}
+			var claims map[string]any
+			if err := json.NewDecoder(body).Decode(&claims); err != nil {
+				t.Fatalf("decoding body: %v", err)
+			}
+			if claims["sub"] != testSubject {
+				t.Errorf("sub = %v, want %q", claims["sub"], testSubject)
+			}
+		})
+	}
+}
+
+func TestTokenGzipTransparentClient(t *testing.T) {
+	_, ts := newTestServer(t, func(cfg *Config) { cfg.GzipMinBytes = 1 })
+
+	// The default transport asks for gzip itself and decodes the body.
+	resp, body := exchange(t, ts, authorizeCode(t, ts, nil), nil)
+	if resp.StatusCode != http.StatusOK || !resp.Uncompressed {
+		t.Fatalf("status = %d, uncompressed = %v; want a gzipped 200", resp.StatusCode, resp.Uncompressed)
+	}
+	if body["access_token"] == nil {
+		t.Errorf("body = %v, want an access_token", body)
+	}
+}
+
+func TestProblemJSON(t *testing.T) {
+	form := url.Values{"grant_type": {"password"}, "client_id": {testClientID}, "client_secret": {testSecret}}
+	tests := []struct {
+		name        string
+		configured  bool
+		accept      string
+		wantProblem bool
+	}{
+		{"default", false, "", false},
+		{"asked for in Accept", false, "application/problem+json", true},
+		{"configured", true, "application/json", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ts := newTestServer(t, func(cfg *Config) { cfg.ProblemJSON = tt.configured })
+			header := map[string]string{}
+			if tt.accept != "" {
+				header["Accept"] = tt.accept
+			}
+
+			resp, body := postForm(t, ts, "/token", form, header)
+			if resp.StatusCode != http.StatusBadRequest || body["error"] != "unsupported_grant_type" {
+				t.Fatalf("status = %d, body %v; want unsupported_grant_type", resp.StatusCode, body)
+			}
+			contentType := resp.Header.Get("Content-Type")
+			if !tt.wantProblem {
+				if contentType != "application/json" || body["type"] != nil {
+					t.Errorf("Content-Type = %q, body %v; want the OAuth error shape", contentType, body)
+				}
+				return
+			}
+			if contentType != "application/problem+json" || body["title"] != "unsupported_grant_type" ||
+				body["status"] != float64(http.StatusBadRequest) || body["type"] == nil {
+				t.Errorf("Content-Type = %q, body %v; want a problem document", contentType, body)
+			}
+
+			// Successful responses are left alone
+			resp, body = exchange(t, ts, authorizeCode(t, ts, nil), nil)
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" || body["access_token"] == nil {
+				t.Errorf("token status = %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package oauth
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile holds the user data returned by /userinfo.
+type Profile struct {
+	Subject string
+	Name    string
+	Email   string
+	Role    string
+	Data    string
+
+	// Display names keyed by language tag (e.g. "pt-BR"), chosen via the
+	// Accept-Language header. Name is the fallback.
+	LocalizedNames map[string]string
+
+	// Organizations the user belongs to, keyed by org_id. A client may ask
+	// for a token scoped to one of them with the organization parameter.
+	Organizations map[string]Membership
+
+	// Data-residency region (e.g. "eu") stamped on the user's tokens;
+	// takes precedence over the client's Region.
+	Region string
+}
+
+// Membership is a user's role in one organization; /userinfo returns it in
+// place of the profile's own Role for organization-scoped tokens.
+type Membership struct {
+	Name string // organization display name
+	Role string
+}
+
+// ProfileSource looks up user profiles by subject.
+type ProfileSource interface {
+	Profile(subject string) (Profile, bool)
+}
+
+// StaticProfiles is an in-memory ProfileSource keyed by subject.
+type StaticProfiles map[string]Profile
+
+func (p StaticProfiles) Profile(subject string) (Profile, bool) {
+	profile, ok := p[subject]
+	return profile, ok
+}
+
+// localizedName picks the display name best matching an Accept-Language
+// header. It returns the chosen language tag ("" for the default name).
+func localizedName(profile Profile, acceptLanguage string) (string, string) {
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		for lang, name := range profile.LocalizedNames {
+			if strings.EqualFold(lang, tag) {
+				return name, lang
+			}
+		}
+		// Fall back to the primary subtag: "pt" and "pt-PT" match "pt-BR"
+		for lang, name := range profile.LocalizedNames {
+			if strings.EqualFold(primarySubtag(lang), primarySubtag(tag)) {
+				return name, lang
+			}
+		}
+	}
+	return profile.Name, ""
+}
+
+func primarySubtag(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	return primary
+}
+
+// parseAcceptLanguage returns the language tags of the header ordered by
+// descending quality, dropping "*" and tags with q=0.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
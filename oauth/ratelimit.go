@@ -0,0 +1,56 @@
+package oauth
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests per Window.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// rateLimiter counts requests per key in fixed windows.
+type rateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{windows: make(map[string]rateWindow)}
+}
+
+// allow records a request for key and reports whether it is within limit.
+// When it isn't, it also returns how long until the window resets.
+func (l *rateLimiter) allow(key string, limit RateLimit) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	window := l.windows[key]
+	if now.Sub(window.start) >= limit.Window {
+		window = rateWindow{start: now}
+	}
+	window.count++
+	l.windows[key] = window
+
+	// Keep the map from growing without bound
+	if len(l.windows) > 10000 {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= limit.Window {
+				delete(l.windows, k)
+			}
+		}
+	}
+
+	if window.count > limit.Requests {
+		return false, limit.Window - now.Sub(window.start)
+	}
+	return true, 0
+}
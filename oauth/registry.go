@@ -0,0 +1,283 @@
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ==========================================
+// File-Backed Registry
+// ==========================================
+
+// registryFile is the on-disk format read by FileRegistry:
+//
+//	{
+//	  "clients": [{"id": "demo-client", "redirect_uri": "http://localhost:8080/cb", "secret": "demo-secret"}],
+//	  "users":   [{"subject": "user_123", "name": "Alice Doe", "email": "alice@example.com"}],
+//	  "scopes":  [{"scope": "read", "display_name": "View your photos"}]
+//	}
+//
+// public_key is a PEM "PUBLIC KEY" block. When "scopes" is present it
+// replaces Config.Scopes.
+type registryFile struct {
+	Clients []registryClient `json:"clients"`
+	Users   []struct {
+		Subject        string            `json:"subject"`
+		Name           string            `json:"name"`
+		Email          string            `json:"email"`
+		Role           string            `json:"role"`
+		Data           string            `json:"data"`
+		LocalizedNames map[string]string `json:"localized_names"`
+		Region         string            `json:"region"`
+		Organizations  map[string]struct {
+			Name string `json:"name"`
+			Role string `json:"role"`
+		} `json:"organizations"`
+	} `json:"users"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// registryClient is one client as written in the file. It is compared
+// between reloads to tell which clients changed.
+type registryClient struct {
+	ID                      string `json:"id"`
+	RedirectURI             string `json:"redirect_uri"`
+	Secret                  string `json:"secret"`
+	DefaultScope            string `json:"default_scope"`
+	DefaultAudience         string `json:"default_audience"`
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method"`
+	PublicKey               string `json:"public_key"`
+	CodeCallbackURI         string `json:"code_callback_uri"`
+	CodeCallbackOnly        bool   `json:"code_callback_only"`
+	MACTokens               bool   `json:"mac_tokens"`
+	SubjectType             string `json:"subject_type"`
+	SectorIdentifier        string `json:"sector_identifier"`
+	Region                  string `json:"region"`
+	Disabled                bool   `json:"disabled"`
+}
+
+// FileRegistry holds clients, user profiles and scopes loaded from a JSON
+// file. It is a ProfileSource, so it can be set as Config.Profiles, and
+// Watch keeps a Server's clients and scopes in sync with the file.
+type FileRegistry struct {
+	path string
+
+	current atomic.Pointer[registrySnapshot]
+	modTime time.Time
+}
+
+// registrySnapshot is one successfully parsed version of the file.
+type registrySnapshot struct {
+	entries  map[string]registryClient
+	clients  map[string]Client
+	profiles StaticProfiles
+	scopes   []Scope // nil when the file lists none
+}
+
+// LoadFileRegistry reads the registry file at path.
+func LoadFileRegistry(path string) (*FileRegistry, error) {
+	f := &FileRegistry{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileRegistry) Profile(subject string) (Profile, bool) {
+	return f.current.Load().profiles.Profile(subject)
+}
+
+// Watch registers the file's clients and scopes with srv, then polls the
+// file every interval and applies it again when it changes. A file that
+// fails to parse is logged and the previous contents stay in effect.
+//
+// Only clients whose entry in the file changed are touched: new entries are
+// registered, edited ones replaced and removed ones unregistered. Clients
+// registered by other means, and secrets rotated since an unchanged entry
+// was loaded, are left alone.
+func (f *FileRegistry) Watch(ctx context.Context, srv *Server, interval time.Duration) {
+	applied := f.current.Load()
+	f.apply(srv, nil, applied)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(f.path)
+			if err != nil {
+				log.Printf("registry %s: %v", f.path, err)
+				continue
+			}
+			if info.ModTime().Equal(f.modTime) {
+				continue
+			}
+			if err := f.reload(); err != nil {
+				log.Printf("registry %s not reloaded: %v", f.path, err)
+				continue
+			}
+			next := f.current.Load()
+			f.apply(srv, applied, next)
+			applied = next
+			log.Printf("registry %s reloaded", f.path)
+		}
+	}()
+}
+
+// apply brings srv from the previous snapshot of the file (nil for the
+// first time) to next, in one step.
+func (f *FileRegistry) apply(srv *Server, previous *registrySnapshot, next *registrySnapshot) {
+	srv.updateClients(func(store map[string]Client) {
+		if previous != nil {
+			for id := range previous.entries {
+				if _, kept := next.entries[id]; !kept {
+					delete(store, id)
+				}
+			}
+		}
+		for id, client := range next.clients {
+			if _, registered := store[id]; registered && previous != nil && previous.entries[id] == next.entries[id] {
+				continue
+			}
+			if err := srv.acceptClient(client); err != nil {
+				log.Printf("registry %s: client not registered: %v", f.path, err)
+				delete(store, id)
+				continue
+			}
+			store[id] = client
+		}
+	})
+	if next.scopes != nil {
+		srv.ReplaceScopes(next.scopes)
+	}
+}
+
+func (f *FileRegistry) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	// Record the mtime even if parsing fails, so a broken file is reported
+	// once rather than on every poll.
+	f.modTime = info.ModTime()
+
+	var file registryFile
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return err
+	}
+
+	entries := make(map[string]registryClient, len(file.Clients))
+	clients := make(map[string]Client, len(file.Clients))
+	for _, c := range file.Clients {
+		if c.ID == "" {
+			return errors.New("client without id")
+		}
+		if _, duplicate := entries[c.ID]; duplicate {
+			return fmt.Errorf("client %q listed twice", c.ID)
+		}
+		var publicKey crypto.PublicKey
+		if c.PublicKey != "" {
+			if publicKey, err = parsePublicKey(c.PublicKey); err != nil {
+				return fmt.Errorf("client %q: %w", c.ID, err)
+			}
+		}
+		entries[c.ID] = c
+		clients[c.ID] = Client{
+			ID:                      c.ID,
+			RedirectURI:             c.RedirectURI,
+			Secret:                  c.Secret,
+			DefaultScope:            c.DefaultScope,
+			DefaultAudience:         c.DefaultAudience,
+			TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
+			PublicKey:               publicKey,
+			CodeCallbackURI:         c.CodeCallbackURI,
+			CodeCallbackOnly:        c.CodeCallbackOnly,
+			MACTokens:               c.MACTokens,
+			SubjectType:             c.SubjectType,
+			SectorIdentifier:        c.SectorIdentifier,
+			Region:                  c.Region,
+			Disabled:                c.Disabled,
+		}
+		if err := checkClient(clients[c.ID]); err != nil {
+			return err
+		}
+	}
+
+	profiles := make(StaticProfiles, len(file.Users))
+	for _, u := range file.Users {
+		if u.Subject == "" {
+			return errors.New("user without subject")
+		}
+		var organizations map[string]Membership
+		for orgID, org := range u.Organizations {
+			if organizations == nil {
+				organizations = make(map[string]Membership, len(u.Organizations))
+			}
+			organizations[orgID] = Membership{Name: org.Name, Role: org.Role}
+		}
+		profiles[u.Subject] = Profile{
+			Subject:        u.Subject,
+			Name:           u.Name,
+			Email:          u.Email,
+			Role:           u.Role,
+			Data:           u.Data,
+			LocalizedNames: u.LocalizedNames,
+			Organizations:  organizations,
+			Region:         u.Region,
+		}
+	}
+
+	for _, scope := range file.Scopes {
+		if scope.Name == "" {
+			return errors.New("scope without name")
+		}
+	}
+
+	f.current.Store(&registrySnapshot{entries: entries, clients: clients, profiles: profiles, scopes: file.Scopes})
+	return nil
+}
+
+// parsePublicKey reads an RSA or P-256 key from a PEM "PUBLIC KEY" block.
+func parsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("public_key is not a PEM PUBLIC KEY block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return key, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return key, nil
+		}
+	}
+	return nil, errors.New("public_key must be an RSA or P-256 key")
+}
@@ -0,0 +1,191 @@
+package oauth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeRegistry writes file as JSON to path with a modification time later
+// than any previous write, so a watcher always notices it.
+func writeRegistry(t *testing.T, path string, file map[string]any, modTime time.Time) {
+	t.Helper()
+	raw, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *Server) lookupClient(id string) (Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clientStore[id]
+	return client, ok
+}
+
+func TestFileRegistryLoadsEveryClientField(t *testing.T) {
+	key := newP256Key(t)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "registry.json")
+	writeRegistry(t, path, map[string]any{
+		"clients": []map[string]any{{
+			"id":                         "jwt-client",
+			"redirect_uri":               testRedirectURI,
+			"token_endpoint_auth_method": AuthMethodPrivateKeyJWT,
+			"public_key":                 string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			"code_callback_uri":          "https://app.example.com/codes",
+			"code_callback_only":         true,
+			"mac_tokens":                 true,
+		}},
+		"scopes": []map[string]any{{"scope": "photos:read", "display_name": "View your photos"}},
+	}, time.Now())
+
+	registry, err := LoadFileRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, ts := newTestServer(t, nil)
+	registry.Watch(context.Background(), srv, time.Hour)
+
+	client, ok := srv.lookupClient("jwt-client")
+	if !ok {
+		t.Fatal("client from the file not registered")
+	}
+	if !key.PublicKey.Equal(client.PublicKey) || client.CodeCallbackURI != "https://app.example.com/codes" ||
+		!client.CodeCallbackOnly || !client.MACTokens {
+		t.Errorf("client = %+v, want every field from the file", client)
+	}
+
+	_, body := get(t, ts, "/scopes", nil)
+	scopes, _ := body["scopes"].([]any)
+	if len(scopes) != 1 || scopes[0].(map[string]any)["scope"] != "photos:read" {
+		t.Errorf("/scopes = %v, want the file's scopes", body)
+	}
+	if params := redirectParams(t, authorize(t, ts, url.Values{"scope": {"read"}})); params.Get("error") != "invalid_scope" {
+		t.Errorf("scope missing from the file: authorize = %v, want invalid_scope", params)
+	}
+}
+
+func TestFileRegistryRejectsBadPublicKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	writeRegistry(t, path, map[string]any{
+		"clients": []map[string]any{{"id": "jwt-client", "token_endpoint_auth_method": AuthMethodPrivateKeyJWT, "public_key": "not a key"}},
+	}, time.Now())
+
+	if _, err := LoadFileRegistry(path); err == nil {
+		t.Fatal("LoadFileRegistry accepted a malformed public_key")
+	}
+}
+
+func TestFileRegistryRejectsClientWithoutSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	for _, method := range []string{"", AuthMethodSecretBasic, AuthMethodSecretPost} {
+		writeRegistry(t, path, map[string]any{
+			"clients": []map[string]any{{"id": "secretless", "redirect_uri": testRedirectURI, "token_endpoint_auth_method": method}},
+		}, time.Now())
+		if _, err := LoadFileRegistry(path); err == nil {
+			t.Errorf("method %q: LoadFileRegistry accepted a client without a secret", method)
+		}
+	}
+
+	writeRegistry(t, path, map[string]any{
+		"clients": []map[string]any{{"id": "public", "redirect_uri": testRedirectURI, "token_endpoint_auth_method": AuthMethodNone}},
+	}, time.Now())
+	if _, err := LoadFileRegistry(path); err != nil {
+		t.Errorf("public client refused: %v", err)
+	}
+}
+
+func TestFileRegistrySkipsPairwiseClientsWithoutSalt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	writeRegistry(t, path, map[string]any{
+		"clients": []map[string]any{
+			{"id": "pairwise", "redirect_uri": testRedirectURI, "secret": "pairwise-secret", "subject_type": SubjectTypePairwise},
+			{"id": "public", "redirect_uri": testRedirectURI, "secret": "public-secret"},
+		},
+	}, time.Now())
+
+	registry, err := LoadFileRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := newTestServer(t, nil)
+	registry.Watch(context.Background(), srv, time.Hour)
+
+	if _, ok := srv.lookupClient("pairwise"); ok {
+		t.Error("pairwise client registered without PairwiseSalt")
+	}
+	if _, ok := srv.lookupClient("public"); !ok {
+		t.Error("other clients in the file not registered")
+	}
+}
+
+func TestFileRegistryReloadKeepsUnchangedClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	modTime := time.Now().Add(-time.Hour)
+	kept := map[string]any{"id": "kept", "redirect_uri": testRedirectURI, "secret": "kept-secret"}
+	writeRegistry(t, path, map[string]any{"clients": []map[string]any{
+		kept,
+		{"id": "edited", "redirect_uri": testRedirectURI, "secret": "old-secret"},
+		{"id": "removed", "redirect_uri": testRedirectURI, "secret": "removed-secret"},
+	}}, modTime)
+
+	registry, err := LoadFileRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := newTestServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registry.Watch(ctx, srv, 10*time.Millisecond)
+
+	rotated, err := srv.rotateClientSecret("kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeRegistry(t, path, map[string]any{"clients": []map[string]any{
+		kept,
+		{"id": "edited", "redirect_uri": testRedirectURI, "secret": "new-secret"},
+		{"id": "added", "redirect_uri": testRedirectURI, "secret": "added-secret"},
+	}}, modTime.Add(time.Minute))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := srv.lookupClient("added"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("registry not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if client, _ := srv.lookupClient("kept"); client.Secret != rotated {
+		t.Errorf("unchanged client: secret = %q, want the rotated one", client.Secret)
+	}
+	if client, _ := srv.lookupClient("edited"); client.Secret != "new-secret" {
+		t.Errorf("edited client: secret = %q, want new-secret", client.Secret)
+	}
+	if _, ok := srv.lookupClient("removed"); ok {
+		t.Error("client removed from the file is still registered")
+	}
+	if _, ok := srv.lookupClient(testClientID); !ok {
+		t.Error("client registered outside the file was dropped")
+	}
+}
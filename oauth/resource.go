@@ -0,0 +1,97 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ==========================================
+// Protected Resource Metadata (RFC 9728)
+// ==========================================
+
+const resourceMetadataPath = "/.well-known/oauth-protected-resource"
+
+// handleResourceMetadata describes /userinfo and the other resource
+// endpoints of this server, so a client that only knows a resource URL can
+// find the authorization server to get a token from.
+func (s *Server) handleResourceMetadata(w http.ResponseWriter, r *http.Request) {
+	issuer := s.issuer(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"resource":                 issuer,
+		"authorization_servers":    []string{issuer},
+		"bearer_methods_supported": []string{"header", "body"},
+	})
+}
+
+// bearerChallenge sets the WWW-Authenticate header of a 401 from a
+// resource endpoint. errCode may be empty when no token was sent at all
+// (RFC 6750 3.1). With Config.AdvertiseResourceMetadata the challenge also
+// points at the metadata document (RFC 9728 5.1).
+func (s *Server) bearerChallenge(w http.ResponseWriter, r *http.Request, errCode string) {
+	challenge := "Bearer"
+	separator := " "
+	if errCode != "" {
+		challenge += fmt.Sprintf(`%serror="%s"`, separator, errCode)
+		separator = ", "
+	}
+	if s.cfg.AdvertiseResourceMetadata {
+		challenge += fmt.Sprintf(`%sresource_metadata="%s"`, separator, s.issuer(r)+resourceMetadataPath)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+// RequireAudience guards a resource server's handler: requests need a
+// valid bearer token whose aud is one of aud, so a token issued for
+// another resource server is refused with 401 invalid_token. MAC tokens
+// are refused too, since the guard can't check their signature.
+func (s *Server) RequireAudience(aud ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				s.bearerChallenge(w, r, "")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			accessToken, err := s.ValidateToken(token)
+			if err != nil || accessToken.MACKey != "" || !slices.Contains(aud, accessToken.Audience) {
+				s.bearerChallenge(w, r, "invalid_token")
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAudience is the same guard for a resource server in another
+// process, validating tokens through introspection. Introspection
+// failures answer 503 rather than letting the request through.
+func (c *IntrospectionClient) RequireAudience(aud ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			result, err := c.ValidateToken(r.Context(), token)
+			if err != nil && !errors.Is(err, ErrInvalidToken) {
+				http.Error(w, "Token validation unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil || result.TokenType != "Bearer" || !slices.Contains(aud, result.Audience) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
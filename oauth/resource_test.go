@@ -0,0 +1,99 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedIssuer(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		want       string
+	}{
+		{"no forwarded headers", "10.0.0.1:4000", nil, "http://localhost:8080"},
+		{"trusted proxy", "10.0.0.1:4000",
+			map[string]string{"X-Forwarded-Host": "auth.example.com", "X-Forwarded-Proto": "https"}, "https://auth.example.com"},
+		{"trusted proxy, host only", "10.0.0.1:4000",
+			map[string]string{"X-Forwarded-Host": "auth.example.com"}, "http://auth.example.com"},
+		{"proxy chain", "10.0.0.1:4000",
+			map[string]string{"X-Forwarded-Host": "auth.example.com, internal:8080", "X-Forwarded-Proto": "https, http"}, "https://auth.example.com"},
+		{"unsupported proto", "10.0.0.1:4000",
+			map[string]string{"X-Forwarded-Host": "auth.example.com", "X-Forwarded-Proto": "gopher"}, "http://auth.example.com"},
+		{"untrusted peer", "192.0.2.7:4000",
+			map[string]string{"X-Forwarded-Host": "evil.example.com", "X-Forwarded-Proto": "https"}, "http://localhost:8080"},
+	}
+	srv, _ := newTestServer(t, func(cfg *Config) {
+		cfg.TrustedProxies = []string{"10.0.0.0/8"}
+		cfg.AdvertiseResourceMetadata = true
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serve := func(path string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", path, nil)
+				req.RemoteAddr = tt.remoteAddr
+				for name, value := range tt.header {
+					req.Header.Set(name, value)
+				}
+				rec := httptest.NewRecorder()
+				srv.Handler().ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := serve(resourceMetadataPath)
+			var body map[string]any
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["resource"] != tt.want {
+				t.Errorf("resource = %v, want %q", body["resource"], tt.want)
+			}
+
+			// The challenge on a 401 points at the same public URL
+			rec = serve("/userinfo")
+			want := `Bearer resource_metadata="` + tt.want + resourceMetadataPath + `"`
+			if got := rec.Header().Get("WWW-Authenticate"); rec.Code != http.StatusUnauthorized || got != want {
+				t.Errorf("userinfo: status = %d, WWW-Authenticate %q; want 401 with %q", rec.Code, got, want)
+			}
+		})
+	}
+}
+
+func TestRequireAudience(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.DefaultAudience = "https://photos.example.com" })
+	srv.RegisterClient(Client{ID: "billing", RedirectURI: testRedirectURI, Secret: "billing-secret", DefaultAudience: "https://billing.example.com"})
+	photosToken := issueToken(t, ts)
+	billingToken := issueTokenFor(t, ts, "billing", "billing-secret")
+
+	guarded := srv.RequireAudience("https://photos.example.com", "https://photos-v2.example.com")(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{"accepted audience", "Bearer " + photosToken, http.StatusNoContent, ""},
+		{"other audience", "Bearer " + billingToken, http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"unknown token", "Bearer nope", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+		{"no token", "", http.StatusUnauthorized, "Bearer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/photos", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			guarded.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus || rec.Header().Get("WWW-Authenticate") != tt.wantChallenge {
+				t.Errorf("status = %d, WWW-Authenticate %q; want %d, %q",
+					rec.Code, rec.Header().Get("WWW-Authenticate"), tt.wantStatus, tt.wantChallenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,608 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ==========================================
+// Configuration & Storage
+// ==========================================
+
+type Config struct {
+	// Public base URL of this server, e.g. the audience expected in
+	// client assertions.
+	Issuer string
+
+	// Audience recorded on tokens when none is requested. Client.DefaultAudience
+	// takes precedence; when both are empty the Issuer is used.
+	DefaultAudience string
+
+	AuthCodeTTL    time.Duration
+	AccessTokenTTL time.Duration
+
+	// Upper bound on request body size, guarding ParseForm against
+	// memory exhaustion. Zero disables the limit.
+	MaxBodyBytes int64
+
+	// Gzip /token and /userinfo responses of at least this many bytes for
+	// clients sending Accept-Encoding: gzip. Zero disables compression.
+	GzipMinBytes int
+
+	// Send error responses as RFC 7807 application/problem+json. Without
+	// it they still are for clients that Accept application/problem+json;
+	// everyone else gets the RFC 6749 {"error": ...} shape.
+	ProblemJSON bool
+
+	// TLS settings returned by TLSConfig, for serving over HTTPS. Cipher
+	// suites only apply up to TLS 1.2; TLS 1.3 suites aren't configurable.
+	MinTLSVersion uint16
+	CipherSuites  []uint16
+
+	// Let clients registered with MACTokens get MAC tokens (a mac_key
+	// signing each /userinfo request) instead of bearer tokens.
+	EnableMACTokens bool
+
+	// Lifetime of the one-time references issued by /token/handoff.
+	HandoffTTL time.Duration
+
+	// How long the previous secret keeps working after a rotation.
+	SecretRotationOverlap time.Duration
+
+	// Scope granted when an authorize request omits the scope parameter.
+	// Client.DefaultScope takes precedence when set.
+	DefaultScope string
+
+	// Reject requests carrying parameters the endpoint does not recognize
+	// instead of ignoring them. Useful when debugging client integrations.
+	StrictParams bool
+
+	// Reject authorize requests whose state is shorter than this, catching
+	// clients that send trivial or empty state. Zero disables the check.
+	MinStateLength int
+
+	// Enable the RFC 7523 JWT bearer grant for assertions signed by one of
+	// TrustedIssuers (issuer -> RSA or P-256 public key).
+	EnableJWTBearerGrant bool
+	TrustedIssuers       map[string]crypto.PublicKey
+
+	// Enable the guest grant (GrantTypeGuest): an authenticated client gets
+	// a short-lived token for subject "guest", limited to GuestScopes.
+	EnableGuestGrant bool
+	GuestScopes      []string
+	GuestTokenTTL    time.Duration
+
+	// Signing algorithms accepted for each kind of incoming JWT. Only RS256
+	// and ES256 are implemented; "none" is always rejected.
+	ClientAssertionAlgs []string
+	JWTBearerAlgs       []string
+
+	// POST a signed event to WebhookURL whenever a token is issued or
+	// revoked. Delivery is asynchronous and retried with backoff, starting
+	// at WebhookRetryBackoff and doubling after each failed attempt.
+	WebhookURL          string
+	WebhookSecret       string
+	WebhookMaxAttempts  int
+	WebhookRetryBackoff time.Duration
+
+	// Per-grant-type limits on token requests from a single IP, so each grant
+	// can be tuned to its own abuse profile. Grants without an entry are
+	// not limited.
+	GrantRateLimits map[string]RateLimit
+
+	// Secret salt mixed into pairwise subject identifiers. It must stay the
+	// same across restarts and replicas, or every pairwise sub changes, so
+	// pairwise clients are refused while it is empty.
+	PairwiseSalt string
+
+	// Accept code_challenge_method=plain in addition to S256.
+	AllowPlainPKCE bool
+
+	// NON-STANDARD. Also accept code_challenge_method S384 and S512 (SHA-384
+	// and SHA-512 in place of SHA-256) for internal clients that ask for
+	// them. Not part of RFC 7636, so no standard client will send them.
+	AllowNonStandardPKCEMethods bool
+
+	// Still require S256 from public clients (token_endpoint_auth_method
+	// "none") when AllowPlainPKCE is on, since nothing else protects
+	// their codes.
+	RequireS256ForPublicClients bool
+
+	// Redirect URI policy, applied on top of the exact match against the
+	// registered URI. Only hosts in RedirectHTTPHosts may use plain http;
+	// when AllowedRedirectHosts is non-empty, no other host is accepted.
+	RedirectHTTPHosts    []string
+	AllowedRedirectHosts []string
+
+	// DEVELOPMENT ONLY. Redirect URIs for which PKCE may be omitted, e.g.
+	// when testing from a browser. Entries match exactly, or by prefix when
+	// they end in "*" ("http://localhost:8080/*"). Every use is logged.
+	InsecurePKCEOptionalRedirectURIs []string
+
+	// DEVELOPMENT ONLY. Serve /debug/pkce, which tells integrators whether
+	// a code_verifier matches their code_challenge.
+	EnablePKCEDebug bool
+
+	// Bind access tokens to the IP address and/or User-Agent of the token
+	// request and reject them at /userinfo when they differ. IP binding breaks
+	// mobile clients that switch networks, so each is opt-in.
+	BindTokenToIP        bool
+	BindTokenToUserAgent bool
+
+	// Bearer credential for the /admin endpoints. Admin endpoints are
+	// disabled while it is empty.
+	AdminToken string
+
+	// Also reject existing tokens once their client is disabled: ValidateToken
+	// refuses them, and with it /userinfo, /introspect, handoffs and
+	// RequireAudience.
+	CheckClientEnabledOnUse bool
+
+	// While maintenance mode is on, authorize/token endpoints answer 503 with
+	// this Retry-After. /userinfo keeps serving existing tokens unless
+	// MaintenanceBlocksUserInfo is set.
+	MaintenanceRetryAfter     time.Duration
+	MaintenanceBlocksUserInfo bool
+
+	// Point 401 responses from /userinfo at the RFC 9728 metadata document
+	// via the resource_metadata WWW-Authenticate parameter.
+	AdvertiseResourceMetadata bool
+
+	// Returns the subject of the user logged in on this request. The demo
+	// has no login screen and simply reports a fixed user.
+	AuthenticateUser func(r *http.Request) (subject string, ok bool)
+
+	// Reverse proxies (IPs or CIDRs) whose X-Forwarded-Host and
+	// X-Forwarded-Proto headers are believed when building the public
+	// issuer URL. Requests from anyone else get Issuer as configured.
+	TrustedProxies []string
+
+	// The supported scopes, served at /scopes. When set, requests for any
+	// other scope fail with invalid_scope. Server.ReplaceScopes swaps them
+	// at runtime.
+	Scopes []Scope
+
+	// Registered scope namespaces for versioned APIs, e.g.
+	// {"api:v2": {"read", "write"}} allows "api:v2:read". When set, a
+	// namespaced scope outside these is rejected with invalid_scope.
+	ScopeNamespaces map[string][]string
+
+	// Grant types through which a scope may be obtained, e.g.
+	// {"offline_access": {"authorization_code"}}. Requesting a listed scope
+	// through any other grant fails with invalid_scope; unlisted scopes are
+	// available to every grant.
+	ScopeGrantTypes map[string][]string
+
+	// How often expired codes, tokens, handoff references and replay
+	// records (assertion jtis, MAC nonces) are swept from memory. Zero
+	// disables the janitor.
+	JanitorInterval time.Duration
+
+	// Cap on a user's authorization codes issued but not yet exchanged.
+	// When reached, PendingCodePolicy either evicts the user's oldest code
+	// (PendingCodesEvictOldest, the default) or refuses the new request
+	// (PendingCodesReject). Zero disables the cap.
+	MaxPendingCodesPerSubject int
+	PendingCodePolicy         string
+
+	// Upper bound on the number of scopes in one request. 0 means no limit.
+	MaxScopes int
+
+	// Decides per requested scope whether the user may be granted it, e.g.
+	// only some users get "admin". Scopes it refuses are dropped from the
+	// grant, or fail the whole request with invalid_scope when
+	// RejectUnauthorizedScopes is set. Nil allows every scope.
+	AuthorizeScope           func(ctx context.Context, client Client, subject string, scope string) bool
+	RejectUnauthorizedScopes bool
+
+	// Randomness for codes, tokens, handoff references and rotated secrets.
+	// Defaults to crypto/rand; a seeded reader makes them reproducible in
+	// tests. Never set it in production.
+	Rand io.Reader
+
+	// Produce authorization codes and access tokens. Codes default to 32
+	// bytes from Rand, base64url encoded; tokens to a UUIDv4 drawn from
+	// Rand. Inject fixed generators for deterministic tests.
+	CodeGenerator  func() (string, error)
+	TokenGenerator func() (string, error)
+
+	// Prepended to every issued access token, e.g. "at_", so secret
+	// scanners can recognise leaked tokens. The prefix is part of the
+	// token value: it is stored and looked up as issued.
+	AccessTokenPrefix string
+
+	// Directory of *.html files overriding the embedded templates
+	// (callback.html, error.html, not_found.html). Empty uses the built-in ones.
+	TemplateDir string
+
+	// Where /userinfo looks up profiles. Without one only "sub" is returned.
+	Profiles ProfileSource
+
+	// Claims whose values live in external systems, returned at /userinfo as
+	// aggregated or distributed claims.
+	ExternalClaims []ExternalClaims
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Issuer:         "http://localhost:8080",
+		AuthCodeTTL:    10 * time.Minute,
+		AccessTokenTTL: 1 * time.Hour,
+		HandoffTTL:     30 * time.Second,
+		MaxBodyBytes:   64 << 10,
+		GzipMinBytes:   1024,
+		MinTLSVersion:  tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		MaintenanceRetryAfter: 5 * time.Minute,
+		WebhookMaxAttempts:    5,
+		WebhookRetryBackoff:   time.Second,
+		JanitorInterval:       time.Minute,
+		GuestScopes:           []string{"read"},
+		GuestTokenTTL:         5 * time.Minute,
+		ClientAssertionAlgs:   []string{"RS256", "ES256"},
+		JWTBearerAlgs:         []string{"RS256", "ES256"},
+		RedirectHTTPHosts:     []string{"localhost", "127.0.0.1", "::1"},
+		SecretRotationOverlap: 24 * time.Hour,
+		DefaultScope:          "read",
+	}
+}
+
+// Token endpoint authentication methods (RFC 7591 section 2)
+const (
+	AuthMethodSecretBasic = "client_secret_basic"
+	AuthMethodSecretPost  = "client_secret_post"
+	AuthMethodNone        = "none"
+
+	// RFC 7523 client assertion signed with Client.PublicKey's private key
+	AuthMethodPrivateKeyJWT = "private_key_jwt"
+)
+
+// Policies for Config.PendingCodePolicy
+const (
+	PendingCodesEvictOldest = "evict_oldest"
+	PendingCodesReject      = "reject"
+)
+
+const (
+	SubjectTypePublic   = "public"
+	SubjectTypePairwise = "pairwise"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const GrantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// GrantTypeGuest is this server's extension grant for anonymous read-only
+// access (RFC 6749 4.5 requires extension grants to be absolute URIs).
+const GrantTypeGuest = "urn:oauth2-example:grant-type:guest"
+
+// guestSubject is the sub of every guest token.
+const guestSubject = "guest"
+
+type Client struct {
+	ID           string
+	RedirectURI  string
+	DefaultScope string
+
+	DefaultAudience string
+
+	// SubjectTypePublic (default) or SubjectTypePairwise. Pairwise clients see
+	// a per-sector sub, derived from SectorIdentifier or the RedirectURI host.
+	SubjectType      string
+	SectorIdentifier string
+
+	// The only way this client may authenticate at the token endpoint.
+	// Empty accepts either client_secret_basic or client_secret_post.
+	TokenEndpointAuthMethod string
+
+	// RSA or P-256 key verifying private_key_jwt client assertions.
+	PublicKey crypto.PublicKey
+
+	// Optional server-side endpoint that also receives each issued code and
+	// state by POST. With CodeCallbackOnly the browser redirect leaves the
+	// code out; if the POST fails the code goes through the redirect anyway.
+	CodeCallbackURI  string
+	CodeCallbackOnly bool
+
+	// Issue MAC tokens instead of bearer tokens (Config.EnableMACTokens).
+	MACTokens bool
+
+	// Data-residency region for tokens issued to this client, unless the
+	// user's profile sets its own.
+	Region string
+
+	// A disabled client is kept registered but can no longer authorize,
+	// obtain tokens or call any other client-authenticated endpoint.
+	Disabled bool
+
+	// During a rotation both secrets authenticate until PreviousSecretExpiresAt.
+	Secret                  string
+	PreviousSecret          string
+	PreviousSecretExpiresAt time.Time
+}
+
+type AuthCode struct {
+	Code                string
+	ClientID            string
+	RedirectURI         string
+	PKCE                bool // false only for the development bypass
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Subject             string
+	Scope               string
+	Organization        string // org_id requested at authorize, if any
+	ExpiresAt           time.Time
+}
+
+type AccessToken struct {
+	Token     string
+	ClientID  string
+	Subject   string
+	Scope     string
+	Audience  string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+
+	// Issued by the guest grant rather than for a logged-in user
+	Guest bool
+
+	// org_id the token is scoped to, from the organization parameter
+	Organization string
+
+	// Data-residency region, from the user's profile or else the client
+	Region string
+
+	// Key the client signs requests with; set only for MAC tokens
+	MACKey string
+
+	// Recorded at issuance for Config.BindTokenToIP / BindTokenToUserAgent
+	ClientIP  string
+	UserAgent string
+}
+
+// Handoff is a single-use reference to an access token, letting a
+// backend-for-frontend pass a token along without exposing it in the browser.
+type Handoff struct {
+	Reference string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Server is an OAuth 2.0 Authorization Server together with the demo
+// Resource Server endpoint. All state is kept in memory.
+type Server struct {
+	cfg Config
+
+	clientStore  map[string]Client
+	codeStore    map[string]AuthCode
+	subjectCodes map[string][]string // subject -> its codes in codeStore, oldest first
+	tokenStore   map[string]AccessToken
+	clientTokens map[string]map[string]struct{} // client ID -> its tokens in tokenStore
+	handoffStore map[string]Handoff
+	usedJTIs     map[string]time.Time // assertion jti -> exp
+	macNonces    map[string]time.Time // MAC token:ts:nonce -> end of the skew window
+	mu           sync.Mutex
+
+	janitorLastRun time.Time // guarded by mu
+
+	// Used for outbound calls such as code callbacks
+	httpClient *http.Client
+
+	templates *template.Template
+
+	maintenance atomic.Bool
+
+	// Config.Scopes, or the set last passed to ReplaceScopes
+	scopes atomic.Pointer[[]Scope]
+
+	// Tokens issued before this instant (Unix nanoseconds) are rejected;
+	// zero when no cutoff is set. See RevokeTokensIssuedBefore.
+	tokensNotBefore atomic.Int64
+
+	subscribers   []subscriber
+	subscribersMu sync.RWMutex
+
+	grantLimiter *rateLimiter
+
+	metrics metrics
+}
+
+func NewServer(cfg Config) *Server {
+	templates, err := loadTemplates(cfg.TemplateDir)
+	if err != nil {
+		log.Printf("template overrides in %q ignored: %v", cfg.TemplateDir, err)
+		templates = template.Must(loadTemplates(""))
+	}
+
+	s := &Server{
+		cfg:          cfg,
+		clientStore:  make(map[string]Client),
+		codeStore:    make(map[string]AuthCode),
+		subjectCodes: make(map[string][]string),
+		tokenStore:   make(map[string]AccessToken),
+		clientTokens: make(map[string]map[string]struct{}),
+		handoffStore: make(map[string]Handoff),
+		usedJTIs:     make(map[string]time.Time),
+		macNonces:    make(map[string]time.Time),
+		httpClient:   &http.Client{Timeout: 5 * time.Second},
+		templates:    templates,
+		grantLimiter: newRateLimiter(),
+	}
+
+	s.scopes.Store(&cfg.Scopes)
+
+	if cfg.JanitorInterval > 0 {
+		go s.runJanitor()
+	}
+	if cfg.WebhookURL != "" {
+		s.Subscribe(EventTokenIssued, s.deliverWebhook)
+		s.Subscribe(EventTokenRevoked, s.deliverWebhook)
+	}
+	return s
+}
+
+// RegisterClient adds client to the registry, replacing any client with
+// the same ID. See acceptClient for the registrations it refuses.
+func (s *Server) RegisterClient(client Client) error {
+	if err := s.acceptClient(client); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.clientStore[client.ID] = client
+	s.mu.Unlock()
+	return nil
+}
+
+// ReplaceClients swaps the whole client registry for clients in one step,
+// so concurrent requests see either the old set or the new one. Nothing is
+// replaced if any client is refused by acceptClient.
+func (s *Server) ReplaceClients(clients []Client) error {
+	store := make(map[string]Client, len(clients))
+	for _, client := range clients {
+		if err := s.acceptClient(client); err != nil {
+			return err
+		}
+		store[client.ID] = client
+	}
+
+	s.mu.Lock()
+	s.clientStore = store
+	s.mu.Unlock()
+	return nil
+}
+
+// checkClient refuses a client that authenticates with a secret but has
+// none registered. Public clients must say so with AuthMethodNone.
+func checkClient(client Client) error {
+	switch client.TokenEndpointAuthMethod {
+	case "", AuthMethodSecretBasic, AuthMethodSecretPost:
+		if client.Secret == "" {
+			return fmt.Errorf("client %q has no secret; register public clients with %q", client.ID, AuthMethodNone)
+		}
+	}
+	return nil
+}
+
+// acceptClient is checkClient plus what depends on this server's config:
+// without a secret salt a pairwise sub is a plain hash of the sector and
+// user id, which anyone who knows both can recompute and link.
+func (s *Server) acceptClient(client Client) error {
+	if err := checkClient(client); err != nil {
+		return err
+	}
+	if client.SubjectType == SubjectTypePairwise && s.cfg.PairwiseSalt == "" {
+		return fmt.Errorf("client %q uses pairwise subjects but Config.PairwiseSalt is empty", client.ID)
+	}
+	return nil
+}
+
+// updateClients lets fn edit the client registry under the lock, so a
+// batch of changes is seen all at once.
+func (s *Server) updateClients(fn func(store map[string]Client)) {
+	s.mu.Lock()
+	fn(s.clientStore)
+	s.mu.Unlock()
+}
+
+// ReplaceScopes swaps the supported scopes (initially Config.Scopes).
+func (s *Server) ReplaceScopes(scopes []Scope) {
+	s.scopes.Store(&scopes)
+}
+
+// registeredScopes returns the supported scopes; see ReplaceScopes.
+func (s *Server) registeredScopes() []Scope {
+	return *s.scopes.Load()
+}
+
+// SetClientDisabled disables or re-enables a registered client without
+// deleting it.
+func (s *Server) SetClientDisabled(clientID string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, exists := s.clientStore[clientID]
+	if !exists {
+		return fmt.Errorf("unknown client %q", clientID)
+	}
+	client.Disabled = disabled
+	s.clientStore[clientID] = client
+	return nil
+}
+
+// SetMaintenance turns maintenance mode on or off.
+func (s *Server) SetMaintenance(enabled bool) {
+	s.maintenance.Store(enabled)
+}
+
+// RevokeTokensIssuedBefore invalidates every access token issued before
+// cutoff, without enumerating them: validation rejects them from now on.
+// Tokens issued afterwards are unaffected. A zero cutoff lifts it.
+func (s *Server) RevokeTokensIssuedBefore(cutoff time.Time) {
+	if cutoff.IsZero() {
+		s.tokensNotBefore.Store(0)
+		return
+	}
+	s.tokensNotBefore.Store(cutoff.UnixNano())
+}
+
+// TLSConfig returns the tls.Config to serve Handler with, applying
+// Config.MinTLSVersion and CipherSuites. Certificates are left to the
+// caller, e.g. http.Server.ListenAndServeTLS.
+func (s *Server) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   s.cfg.MinTLSVersion,
+		CipherSuites: s.cfg.CipherSuites,
+	}
+}
+
+// Handler returns the HTTP handler serving every endpoint of the server.
+func (s *Server) Handler() http.Handler {
+	userInfo := s.gzipResponse(s.handleUserInfo)
+	if s.cfg.MaintenanceBlocksUserInfo {
+		userInfo = s.unlessMaintenance(userInfo)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.unlessMaintenance(s.handleAuthorize))
+	mux.HandleFunc("/token", s.unlessMaintenance(s.gzipResponse(s.handleToken)))
+	mux.HandleFunc("/userinfo", userInfo)
+	mux.HandleFunc("/client/rotate-secret", s.unlessMaintenance(s.handleRotateSecret))
+	mux.HandleFunc("/token/handoff", s.unlessMaintenance(s.handleHandoff))
+	mux.HandleFunc("/token/redeem", s.unlessMaintenance(s.handleRedeem))
+	mux.HandleFunc("/token/info", s.unlessMaintenance(s.handleTokenInfo))
+	mux.HandleFunc("/introspect", s.handleIntrospect)
+	mux.HandleFunc("/admin/revoke-client", s.handleRevokeClient)
+	mux.HandleFunc("/admin/disable-client", s.handleSetClientDisabled(true))
+	mux.HandleFunc("/admin/enable-client", s.handleSetClientDisabled(false))
+	mux.HandleFunc("/admin/maintenance", s.handleMaintenance)
+	mux.HandleFunc("/admin/revoke-before", s.handleRevokeBefore)
+	mux.HandleFunc("/admin/stats", s.handleStats)
+	mux.HandleFunc(resourceMetadataPath, s.handleResourceMetadata)
+	mux.HandleFunc("/scopes", s.handleScopes)
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	if s.cfg.EnablePKCEDebug {
+		mux.HandleFunc("/debug/pkce", s.handlePKCEDebug)
+	}
+	mux.HandleFunc("/cb", s.handleCallback) // Helper for the demo
+	mux.HandleFunc("/", s.handleNotFound)
+	return s.limitBody(s.problemResponse(mux))
+}
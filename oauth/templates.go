@@ -0,0 +1,44 @@
+package oauth
+
+import (
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+// ==========================================
+// HTML Templates
+// ==========================================
+
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// loadTemplates parses the embedded templates and then any *.html file in
+// dir, so operators can replace individual screens (same file name) without
+// rebuilding.
+func loadTemplates(dir string) (*template.Template, error) {
+	tmpl, err := template.ParseFS(embeddedTemplates, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return tmpl, nil
+	}
+
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(overrides) == 0 {
+		return tmpl, err
+	}
+	return tmpl.ParseFiles(overrides...)
+}
+
+// render executes the named template (e.g. "callback.html") as an HTML response.
+func (s *Server) render(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := s.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("render %s: %v", name, err)
+	}
+}
@@ -0,0 +1,45 @@
+package oauth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ==========================================
+// Multi-Tenancy
+// ==========================================
+
+// TenantHandler serves several independent Servers from one listener,
+// routed by path prefix: /t/{tenant}/authorize, /t/{tenant}/token, ...
+// Each tenant has its own Config (and so its own Issuer), clients and
+// token store, so a token issued by one tenant never validates at another.
+// Set each tenant's Issuer to include its prefix, e.g.
+// "https://auth.example.com/t/acme".
+func TenantHandler(tenants map[string]*Server) http.Handler {
+	handlers := make(map[string]http.Handler, len(tenants))
+	for name, srv := range tenants {
+		handlers[name] = http.StripPrefix("/t/"+name, srv.Handler())
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, "/t/")
+		if !ok {
+			jsonError(w, "not_found", http.StatusNotFound)
+			return
+		}
+		// StripPrefix would turn /t/{tenant} into an empty path, which the
+		// tenant's mux redirects to /, out of the tenant.
+		name, _, found := strings.Cut(rest, "/")
+		if !found {
+			jsonError(w, "not_found", http.StatusNotFound)
+			return
+		}
+
+		handler, exists := handlers[name]
+		if !exists {
+			jsonError(w, "unknown_tenant", http.StatusNotFound)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
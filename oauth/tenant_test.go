@@ -0,0 +1,74 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newTenantServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	tenants := make(map[string]*Server)
+	ts := httptest.NewUnstartedServer(nil)
+	for _, name := range []string{"a", "b"} {
+		cfg := DefaultConfig()
+		cfg.JanitorInterval = 0
+		cfg.Issuer = "http://" + ts.Listener.Addr().String() + "/t/" + name
+		cfg.AuthenticateUser = func(*http.Request) (string, bool) { return testSubject, true }
+		srv := NewServer(cfg)
+		srv.RegisterClient(Client{ID: testClientID, RedirectURI: testRedirectURI, Secret: testSecret})
+		tenants[name] = srv
+	}
+	ts.Config.Handler = TenantHandler(tenants)
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestTenantsDoNotShareTokens(t *testing.T) {
+	ts := newTenantServer(t)
+
+	resp, _ := get(t, ts, "/t/a/authorize?"+authorizeQuery(nil).Encode(), nil)
+	code := redirectParams(t, resp).Get("code")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+		"client_id":     {testClientID},
+		"client_secret": {testSecret},
+	}
+	resp, body := postForm(t, ts, "/t/a/token", form, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+	}
+	token := body["access_token"].(string)
+
+	if resp, body := get(t, ts, "/t/a/userinfo", bearer(token)); resp.StatusCode != http.StatusOK {
+		t.Errorf("issuing tenant: userinfo status = %d, body %v", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, ts, "/t/b/userinfo", bearer(token)); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("other tenant: userinfo status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestTenantRouting(t *testing.T) {
+	ts := newTenantServer(t)
+
+	tests := []struct {
+		path      string
+		wantError string
+	}{
+		{"/t/a", "not_found"},
+		{"/t/c/userinfo", "unknown_tenant"},
+		{"/userinfo", "not_found"},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, ts, tt.path, nil)
+		if resp.StatusCode != http.StatusNotFound || body["error"] != tt.wantError {
+			t.Errorf("%s: status = %d, Location %q, body %v; want 404 %s",
+				tt.path, resp.StatusCode, resp.Header.Get("Location"), body, tt.wantError)
+		}
+	}
+}
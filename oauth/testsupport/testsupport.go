@@ -0,0 +1,214 @@
+// Package testsupport runs the authorization code + PKCE flow against an
+// in-process oauth.Server, so tests of resource servers and clients can get
+// a real access token in one call.
+package testsupport
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"oauth2-example/oauth"
+)
+
+// NewServer starts cfg on an httptest.Server with clients registered, and
+// the Issuer set to the test server's URL. When cfg has no
+// AuthenticateUser, every authorize request is made by subject. Close the
+// returned httptest.Server when done.
+func NewServer(cfg oauth.Config, subject string, clients ...oauth.Client) (*oauth.Server, *httptest.Server) {
+	if cfg.AuthenticateUser == nil {
+		cfg.AuthenticateUser = func(*http.Request) (string, bool) { return subject, true }
+	}
+
+	ts := httptest.NewUnstartedServer(nil)
+	cfg.Issuer = "http://" + ts.Listener.Addr().String()
+	srv := oauth.NewServer(cfg)
+	for _, client := range clients {
+		if err := srv.RegisterClient(client); err != nil {
+			panic("testsupport: " + err.Error())
+		}
+	}
+	ts.Config.Handler = srv.Handler()
+	ts.Start()
+	return srv, ts
+}
+
+// Options configures one run of the flow.
+type Options struct {
+	// The client to authorize as. Its Secret, if any, is sent as
+	// client_secret_basic, or client_secret_post when that is its
+	// TokenEndpointAuthMethod.
+	Client oauth.Client
+	Scope  string
+
+	// Extra authorize parameters, e.g. "organization" or "response_mode".
+	Params url.Values
+}
+
+// Token is a successful token response.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// Error is an OAuth error returned by either step, so tests can expect a
+// specific one:
+//
+//	var oauthErr *testsupport.Error
+//	if errors.As(err, &oauthErr) && oauthErr.Code == "invalid_scope" { ... }
+type Error struct {
+	Step        string // "authorize" or "token"
+	Status      int    // HTTP status; 302 for errors sent to the redirect_uri
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("%s: %s (%d)", e.Step, e.Code, e.Status)
+	}
+	return fmt.Sprintf("%s: %s: %s (%d)", e.Step, e.Code, e.Description, e.Status)
+}
+
+// noRedirect stops at the authorize redirect so the code can be read.
+var noRedirect = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// AuthorizationCode performs the full flow against the server at baseURL
+// (an httptest.Server's URL): authorize with an S256 challenge, then
+// exchange the code with its verifier.
+func AuthorizationCode(ctx context.Context, baseURL string, opts Options) (*Token, error) {
+	verifier, err := newVerifier()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {opts.Client.ID},
+		"redirect_uri":          {opts.Client.RedirectURI},
+		"state":                 {"testsupport-state"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if opts.Scope != "" {
+		query.Set("scope", opts.Scope)
+	}
+	for name, values := range opts.Params {
+		query[name] = values
+	}
+
+	code, err := authorize(ctx, baseURL+"/authorize?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return exchange(ctx, baseURL+"/token", opts.Client, code, verifier)
+}
+
+func authorize(ctx context.Context, authorizeURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", authorizeURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := noRedirect.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", readError("authorize", resp)
+	}
+	redirect, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	params := redirect.Query()
+	if redirect.Fragment != "" {
+		if params, err = url.ParseQuery(redirect.Fragment); err != nil {
+			return "", err
+		}
+	}
+	if errCode := params.Get("error"); errCode != "" {
+		return "", &Error{Step: "authorize", Status: resp.StatusCode, Code: errCode, Description: params.Get("error_description")}
+	}
+	if params.Get("code") == "" {
+		return "", fmt.Errorf("authorize: redirect without code: %s", location)
+	}
+	return params.Get("code"), nil
+}
+
+func exchange(ctx context.Context, tokenURL string, client oauth.Client, code string, verifier string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.RedirectURI},
+		"code_verifier": {verifier},
+	}
+	basicAuth := client.Secret != "" && client.TokenEndpointAuthMethod != oauth.AuthMethodSecretPost
+	if !basicAuth {
+		form.Set("client_id", client.ID)
+		if client.Secret != "" {
+			form.Set("client_secret", client.Secret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicAuth {
+		req.SetBasicAuth(client.ID, client.Secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError("token", resp)
+	}
+	var token Token
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// readError turns a non-redirect error response into an *Error.
+func readError(step string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	var oauthErr struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &oauthErr) != nil || oauthErr.Error == "" {
+		return &Error{Step: step, Status: resp.StatusCode, Code: "unexpected_response", Description: strings.TrimSpace(string(body))}
+	}
+	return &Error{Step: step, Status: resp.StatusCode, Code: oauthErr.Error, Description: oauthErr.ErrorDescription}
+}
+
+// newVerifier returns a 43-character code_verifier (RFC 7636 4.1).
+func newVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
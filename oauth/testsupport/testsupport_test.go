@@ -0,0 +1,98 @@
+package testsupport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"oauth2-example/oauth"
+	"oauth2-example/oauth/testsupport"
+)
+
+const redirectURI = "http://localhost:8080/cb"
+
+var (
+	basicClient = oauth.Client{ID: "basic-client", RedirectURI: redirectURI, Secret: "basic-secret", TokenEndpointAuthMethod: oauth.AuthMethodSecretBasic}
+	postClient  = oauth.Client{ID: "post-client", RedirectURI: redirectURI, Secret: "post-secret", TokenEndpointAuthMethod: oauth.AuthMethodSecretPost}
+)
+
+func newServer(t *testing.T) string {
+	t.Helper()
+	cfg := oauth.DefaultConfig()
+	cfg.JanitorInterval = 0
+	cfg.Scopes = []oauth.Scope{{Name: "read"}}
+	_, ts := testsupport.NewServer(cfg, "user_123", basicClient, postClient)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestAuthorizationCodeReturnsUsableToken(t *testing.T) {
+	baseURL := newServer(t)
+
+	token, err := testsupport.AuthorizationCode(context.Background(), baseURL, testsupport.Options{Client: basicClient, Scope: "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken == "" || token.TokenType != "Bearer" || token.Scope != "read" {
+		t.Fatalf("token = %+v", token)
+	}
+
+	req, err := http.NewRequest("GET", baseURL+"/userinfo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil || resp.StatusCode != http.StatusOK || claims.Sub != "user_123" {
+		t.Errorf("userinfo status = %d, sub %q, err %v; want 200 for user_123", resp.StatusCode, claims.Sub, err)
+	}
+}
+
+// The server only accepts each client's registered method, so a token
+// proves the helper picked it.
+func TestAuthorizationCodeUsesRegisteredAuthMethod(t *testing.T) {
+	baseURL := newServer(t)
+
+	for _, client := range []oauth.Client{basicClient, postClient} {
+		if _, err := testsupport.AuthorizationCode(context.Background(), baseURL, testsupport.Options{Client: client}); err != nil {
+			t.Errorf("%s: %v", client.TokenEndpointAuthMethod, err)
+		}
+	}
+}
+
+func TestAuthorizationCodeReturnsOAuthErrors(t *testing.T) {
+	baseURL := newServer(t)
+	wrongSecret := postClient
+	wrongSecret.Secret = "wrong"
+
+	tests := []struct {
+		name       string
+		opts       testsupport.Options
+		wantStep   string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown scope", testsupport.Options{Client: basicClient, Scope: "no-such-scope"}, "authorize", http.StatusFound, "invalid_scope"},
+		{"wrong secret", testsupport.Options{Client: wrongSecret}, "token", http.StatusUnauthorized, "invalid_client"},
+	}
+	for _, tt := range tests {
+		_, err := testsupport.AuthorizationCode(context.Background(), baseURL, tt.opts)
+		var oauthErr *testsupport.Error
+		if !errors.As(err, &oauthErr) {
+			t.Errorf("%s: err = %v, want a *testsupport.Error", tt.name, err)
+			continue
+		}
+		if oauthErr.Step != tt.wantStep || oauthErr.Status != tt.wantStatus || oauthErr.Code != tt.wantCode {
+			t.Errorf("%s: err = %+v, want %s %d %s", tt.name, oauthErr, tt.wantStep, tt.wantStatus, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,769 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ==========================================
+// Utilities
+// ==========================================
+
+func verifyPKCE(method string, challenge string, verifier string) bool {
+	switch method {
+	case "S256", "S384", "S512":
+		// Compare with challenge
+		computed, _ := pkceChallenge(method, verifier)
+		return computed == challenge
+	case "plain":
+		return verifier != "" && secretMatches(challenge, verifier)
+	default:
+		return false
+	}
+}
+
+// pkceChallenge derives the code_challenge for a verifier. S384 and S512
+// are non-standard (Config.AllowNonStandardPKCEMethods).
+func pkceChallenge(method string, verifier string) (string, bool) {
+	// 1. Hash the verifier
+	var hash []byte
+	switch method {
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		hash = sum[:]
+	case "S384":
+		sum := sha512.Sum384([]byte(verifier))
+		hash = sum[:]
+	case "S512":
+		sum := sha512.Sum512([]byte(verifier))
+		hash = sum[:]
+	case "plain":
+		return verifier, true
+	default:
+		return "", false
+	}
+
+	// 2. Base64 URL Encode (no padding)
+	return base64.RawURLEncoding.EncodeToString(hash), true
+}
+
+// challengeWellFormed reports whether challenge could be the output of a
+// hash method: unpadded base64url of exactly the digest length, i.e. 43
+// characters for S256. A malformed challenge could never match a verifier,
+// so rejecting it at authorize spares the client a confusing failure at
+// the token endpoint. plain challenges are left alone.
+func challengeWellFormed(method string, challenge string) bool {
+	if method == "plain" {
+		return true
+	}
+	example, ok := pkceChallenge(method, "")
+	if !ok || len(challenge) != len(example) {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+// The scheme is case-insensitive (RFC 6750 / RFC 7235) and surrounding
+// whitespace is ignored.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// issuer returns the public issuer URL for a request. Behind a trusted
+// proxy the scheme and host come from X-Forwarded-Proto/-Host, keeping the
+// path of Config.Issuer (which matters for TenantHandler prefixes).
+func (s *Server) issuer(r *http.Request) string {
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" || !s.fromTrustedProxy(r) {
+		return s.cfg.Issuer
+	}
+	// Proxies may append: "client-facing, next-hop"
+	host, _, _ = strings.Cut(host, ",")
+
+	configured, err := url.Parse(s.cfg.Issuer)
+	if err != nil {
+		return s.cfg.Issuer
+	}
+	public := url.URL{Scheme: configured.Scheme, Host: strings.TrimSpace(host), Path: configured.Path}
+	if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); proto == "http" || proto == "https" {
+		public.Scheme = proto
+	}
+	return public.String()
+}
+
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	ip := net.ParseIP(remoteIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range s.cfg.TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bindingMatches reports whether the request comes from the same IP and
+// User-Agent the token was issued to, for whichever bindings are enabled.
+func (s *Server) bindingMatches(accessToken *AccessToken, r *http.Request) bool {
+	if s.cfg.BindTokenToIP && accessToken.ClientIP != remoteIP(r) {
+		return false
+	}
+	if s.cfg.BindTokenToUserAgent && accessToken.UserAgent != r.UserAgent() {
+		return false
+	}
+	return true
+}
+
+// validateRedirectURI applies the redirect URI policy: no fragment
+// (RFC 6749 3.1.2), https unless the host is in Config.RedirectHTTPHosts,
+// and a host from Config.AllowedRedirectHosts when that list is set.
+func (s *Server) validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("redirect_uri must be an absolute URL")
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return errors.New("redirect_uri must not contain a fragment")
+	}
+
+	host := u.Hostname()
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !slices.Contains(s.cfg.RedirectHTTPHosts, host) {
+			return errors.New("redirect_uri must use https")
+		}
+	default:
+		return errors.New("redirect_uri must use https")
+	}
+
+	if len(s.cfg.AllowedRedirectHosts) > 0 && !slices.Contains(s.cfg.AllowedRedirectHosts, host) {
+		return errors.New("redirect_uri host is not allowed")
+	}
+	return nil
+}
+
+// authorizeRedirect adds the authorization response parameters to
+// redirectURI: in the query by default, in the fragment for
+// response_mode=fragment (OAuth 2.0 Multiple Response Types, section 2.1).
+func authorizeRedirect(redirectURI string, responseMode string, params url.Values) string {
+	if responseMode == "fragment" {
+		return redirectURI + "#" + params.Encode()
+	}
+	separator := "?"
+	if strings.Contains(redirectURI, "?") {
+		separator = "&"
+	}
+	return redirectURI + separator + params.Encode()
+}
+
+// authenticateClient checks the client credentials using the method the
+// client registered: client_secret_basic (Authorization header),
+// client_secret_post (form body), private_key_jwt (client_assertion) or
+// none (public client, client_id only). Clients without a registered
+// method may use either secret method. Credentials are only read from the
+// request body, never the URL query (RFC 6749 2.3.1). Callers must have
+// parsed the form.
+func (s *Server) authenticateClient(r *http.Request) (Client, bool) {
+	clientID, secret, hasBasic := r.BasicAuth()
+	hasPost := r.PostForm.Get("client_secret") != ""
+	hasAssertion := r.PostForm.Get("client_assertion_type") != ""
+
+	presented := 0
+	for _, has := range []bool{hasBasic, hasPost, hasAssertion} {
+		if has {
+			presented++
+		}
+	}
+	if presented > 1 {
+		return Client{}, false // RFC 6749 2.3: only one method per request
+	}
+	if hasAssertion {
+		return s.authenticateClientAssertion(r)
+	}
+
+	method := AuthMethodSecretBasic
+	if !hasBasic {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+		method = AuthMethodSecretPost
+		if secret == "" {
+			method = AuthMethodNone
+		}
+	}
+
+	s.mu.Lock()
+	client, exists := s.clientStore[clientID]
+	s.mu.Unlock()
+
+	if !exists {
+		return Client{}, false
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case "":
+		if method == AuthMethodNone {
+			return Client{}, false
+		}
+	case method:
+		if method == AuthMethodNone {
+			return client, true
+		}
+	default:
+		return Client{}, false
+	}
+
+	// An empty secret must not match a client registered without one
+	if secret == "" {
+		return Client{}, false
+	}
+	if secretMatches(client.Secret, secret) {
+		return client, true
+	}
+	if client.PreviousSecret != "" && time.Now().Before(client.PreviousSecretExpiresAt) &&
+		secretMatches(client.PreviousSecret, secret) {
+		return client, true
+	}
+	return Client{}, false
+}
+
+// presentedClientID is the client_id a request claims, authenticated or not.
+func presentedClientID(r *http.Request) string {
+	if id, _, ok := r.BasicAuth(); ok {
+		return id
+	}
+	return r.PostForm.Get("client_id")
+}
+
+// presentedSecret is the client secret a request carries, from the
+// Authorization header or the form body.
+func presentedSecret(r *http.Request) string {
+	if _, secret, ok := r.BasicAuth(); ok {
+		return secret
+	}
+	return r.PostForm.Get("client_secret")
+}
+
+// authenticateClientAssertion implements private_key_jwt (RFC 7523 2.2 and 3).
+func (s *Server) authenticateClientAssertion(r *http.Request) (Client, bool) {
+	if r.PostForm.Get("client_assertion_type") != clientAssertionType {
+		return Client{}, false
+	}
+	assertion := r.PostForm.Get("client_assertion")
+
+	_, unverified, err := parseJWT(assertion)
+	if err != nil {
+		return Client{}, false
+	}
+
+	s.mu.Lock()
+	client, exists := s.clientStore[unverified.Issuer]
+	s.mu.Unlock()
+
+	if !exists || client.TokenEndpointAuthMethod != AuthMethodPrivateKeyJWT || client.PublicKey == nil {
+		return Client{}, false
+	}
+	if id := r.PostForm.Get("client_id"); id != "" && id != client.ID {
+		return Client{}, false
+	}
+
+	claims, err := verifyJWT(assertion, client.PublicKey, s.cfg.ClientAssertionAlgs)
+	if err != nil {
+		return Client{}, false
+	}
+
+	now := time.Now()
+	issuer := s.issuer(r)
+	if claims.Issuer != client.ID || claims.Subject != client.ID || claims.ID == "" ||
+		!claims.hasAudience(issuer, issuer+"/token") || claims.validateTimes(now) != nil {
+		return Client{}, false
+	}
+
+	if !s.useJTI(client.ID, claims.ID, time.Unix(claims.ExpiresAt, 0)) {
+		return Client{}, false
+	}
+	return client, true
+}
+
+// useJTI records an assertion's jti until it expires, so each assertion can
+// be used only once. It reports false for a replay. The janitor drops
+// entries once they expire, when the assertion would be refused anyway.
+func (s *Server) useJTI(issuer string, jti string, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return useOnce(s.usedJTIs, issuer+":"+jti, expiresAt)
+}
+
+// useOnce adds key to seen unless it is already there. The caller holds s.mu.
+func useOnce(seen map[string]time.Time, key string, expiresAt time.Time) bool {
+	if _, used := seen[key]; used {
+		return false
+	}
+	seen[key] = expiresAt
+	return true
+}
+
+func secretMatches(expected string, given string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(given)) == 1
+}
+
+// rotateClientSecret issues a new secret for the client. The old one stays
+// valid for Config.SecretRotationOverlap so deployed clients can be updated.
+func (s *Server) rotateClientSecret(clientID string) (string, error) {
+	secret, err := s.randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, exists := s.clientStore[clientID]
+	if !exists {
+		return "", fmt.Errorf("unknown client %q", clientID)
+	}
+	client.PreviousSecret = client.Secret
+	client.PreviousSecretExpiresAt = time.Now().Add(s.cfg.SecretRotationOverlap)
+	client.Secret = secret
+	s.clientStore[clientID] = client
+
+	return secret, nil
+}
+
+// deliverCode POSTs an issued code to the client's server-side callback.
+func (s *Server) deliverCode(client Client, code string, state string) error {
+	resp, err := s.httpClient.PostForm(client.CodeCallbackURI, url.Values{
+		"code":      {code},
+		"state":     {state},
+		"client_id": {client.ID},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// isAdmin checks the request's Bearer credential against Config.AdminToken.
+// It is deliberately separate from client authentication.
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.cfg.AdminToken == "" {
+		return false
+	}
+	token, ok := bearerToken(r)
+	return ok && secretMatches(s.cfg.AdminToken, token)
+}
+
+// revokeClientTokens deletes every access token issued to clientID and
+// returns how many there were.
+func (s *Server) revokeClientTokens(clientID string) int {
+	var revoked []AccessToken
+
+	s.mu.Lock()
+	for token := range s.clientTokens[clientID] {
+		if accessToken, exists := s.dropToken(token); exists {
+			revoked = append(revoked, accessToken)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, accessToken := range revoked {
+		s.publish(tokenEvent(EventTokenRevoked, accessToken))
+	}
+	return len(revoked)
+}
+
+// issueAccessToken creates and stores a new access token.
+func (s *Server) issueAccessToken(r *http.Request, client Client, subject string, scope string) (AccessToken, error) {
+	accessToken, err := s.newAccessToken(r, client, subject, scope, s.cfg.AccessTokenTTL)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	s.storeAccessToken(accessToken)
+	return accessToken, nil
+}
+
+// newAccessToken builds a token without storing it, for grants that need
+// to adjust it first; storeAccessToken makes it valid.
+func (s *Server) newAccessToken(r *http.Request, client Client, subject string, scope string, ttl time.Duration) (AccessToken, error) {
+	token, err := s.newToken()
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	var macKey string
+	if s.cfg.EnableMACTokens && client.MACTokens {
+		if macKey, err = s.randomString(32); err != nil {
+			return AccessToken{}, err
+		}
+	}
+
+	now := time.Now()
+	return AccessToken{
+		Token:     token,
+		MACKey:    macKey,
+		ClientID:  client.ID,
+		Subject:   subject,
+		Scope:     scope,
+		Audience:  s.defaultAudience(client),
+		IssuedAt:  now,
+		NotBefore: now,
+		ExpiresAt: now.Add(ttl),
+		Region:    s.regionFor(client, subject),
+		ClientIP:  remoteIP(r),
+		UserAgent: r.UserAgent(),
+	}, nil
+}
+
+func (s *Server) storeAccessToken(accessToken AccessToken) {
+	s.mu.Lock()
+	s.tokenStore[accessToken.Token] = accessToken
+	tokens := s.clientTokens[accessToken.ClientID]
+	if tokens == nil {
+		tokens = make(map[string]struct{})
+		s.clientTokens[accessToken.ClientID] = tokens
+	}
+	tokens[accessToken.Token] = struct{}{}
+	s.mu.Unlock()
+
+	s.publish(tokenEvent(EventTokenIssued, accessToken))
+}
+
+// dropToken removes token from tokenStore and its client's index, returning
+// it if it was there. The caller holds s.mu.
+func (s *Server) dropToken(token string) (AccessToken, bool) {
+	accessToken, exists := s.tokenStore[token]
+	if !exists {
+		return AccessToken{}, false
+	}
+	delete(s.tokenStore, token)
+
+	tokens := s.clientTokens[accessToken.ClientID]
+	delete(tokens, token)
+	if len(tokens) == 0 {
+		delete(s.clientTokens, accessToken.ClientID)
+	}
+	return accessToken, true
+}
+
+func writeTokenResponse(w http.ResponseWriter, accessToken AccessToken) {
+	body := map[string]any{
+		"access_token": accessToken.Token,
+		"expires_in":   int(accessToken.ExpiresAt.Sub(accessToken.NotBefore).Seconds()),
+		"scope":        accessToken.Scope,
+	}
+	setTokenType(body, accessToken)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// scopeSubset reports whether every scope in requested is also in granted.
+func scopeSubset(requested string, granted string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(grantedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// errScopeNotAuthorized is returned by grantScope when a requested scope
+// fails Config.AuthorizeScope and RejectUnauthorizedScopes is set, or when
+// every requested scope fails it.
+var errScopeNotAuthorized = errors.New("scope not authorized")
+
+// grantScope applies Config.MaxScopes, Scopes, ScopeNamespaces,
+// ScopeGrantTypes and AuthorizeScope to a scope string requested through
+// grantType and returns the scope to grant.
+func (s *Server) grantScope(ctx context.Context, client Client, subject string, requested string, grantType string) (string, error) {
+	scopes := strings.Fields(requested)
+	if s.cfg.MaxScopes > 0 && len(scopes) > s.cfg.MaxScopes {
+		return "", fmt.Errorf("at most %d scopes may be requested", s.cfg.MaxScopes)
+	}
+	if err := s.checkRegisteredScopes(requested); err != nil {
+		return "", err
+	}
+	if err := s.checkScopeNamespaces(requested); err != nil {
+		return "", err
+	}
+	if err := s.checkScopeGrantTypes(requested, grantType); err != nil {
+		return "", err
+	}
+	// Nothing requested is not the same as everything refused
+	if s.cfg.AuthorizeScope == nil || len(scopes) == 0 {
+		return requested, nil
+	}
+
+	granted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if s.cfg.AuthorizeScope(ctx, client, subject, scope) {
+			granted = append(granted, scope)
+		} else if s.cfg.RejectUnauthorizedScopes {
+			return "", fmt.Errorf("%w: %s", errScopeNotAuthorized, scope)
+		}
+	}
+	if len(granted) == 0 {
+		return "", errScopeNotAuthorized
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// pkceOptional reports whether redirectURI is listed in
+// Config.InsecurePKCEOptionalRedirectURIs.
+func (s *Server) pkceOptional(redirectURI string) bool {
+	for _, pattern := range s.cfg.InsecurePKCEOptionalRedirectURIs {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(redirectURI, prefix) {
+				return true
+			}
+		} else if pattern == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// membership looks up the user's role in an organization.
+func (s *Server) membership(subject string, orgID string) (Membership, bool) {
+	if s.cfg.Profiles == nil {
+		return Membership{}, false
+	}
+	profile, ok := s.cfg.Profiles.Profile(subject)
+	if !ok {
+		return Membership{}, false
+	}
+	membership, ok := profile.Organizations[orgID]
+	return membership, ok
+}
+
+// regionFor picks the region stamped on a token: the user's, if their
+// profile has one, else the client's.
+func (s *Server) regionFor(client Client, subject string) string {
+	if s.cfg.Profiles != nil {
+		if profile, ok := s.cfg.Profiles.Profile(subject); ok && profile.Region != "" {
+			return profile.Region
+		}
+	}
+	return client.Region
+}
+
+// makeRoomForCode enforces Config.MaxPendingCodesPerSubject before a new
+// code is stored for subject, evicting the oldest pending code or
+// reporting false under PendingCodesReject. The caller holds s.mu.
+func (s *Server) makeRoomForCode(subject string) bool {
+	if s.cfg.MaxPendingCodesPerSubject <= 0 {
+		return true
+	}
+
+	// Expired codes don't count; drop them now rather than wait for the janitor
+	now := time.Now()
+	for _, code := range slices.Clone(s.subjectCodes[subject]) {
+		if now.After(s.codeStore[code].ExpiresAt) {
+			s.dropCode(code)
+		}
+	}
+
+	pending := s.subjectCodes[subject]
+	if len(pending) < s.cfg.MaxPendingCodesPerSubject {
+		return true
+	}
+	if s.cfg.PendingCodePolicy == PendingCodesReject {
+		return false
+	}
+	s.dropCode(pending[0])
+	return true
+}
+
+// storeCode adds authCode to codeStore and its subject's index. The caller
+// holds s.mu.
+func (s *Server) storeCode(authCode AuthCode) {
+	s.codeStore[authCode.Code] = authCode
+	s.subjectCodes[authCode.Subject] = append(s.subjectCodes[authCode.Subject], authCode.Code)
+}
+
+// dropCode removes code from codeStore and its subject's index, returning
+// it if it was there. The caller holds s.mu.
+func (s *Server) dropCode(code string) (AuthCode, bool) {
+	authCode, exists := s.codeStore[code]
+	if !exists {
+		return AuthCode{}, false
+	}
+	delete(s.codeStore, code)
+
+	codes := slices.DeleteFunc(s.subjectCodes[authCode.Subject], func(c string) bool { return c == code })
+	if len(codes) == 0 {
+		delete(s.subjectCodes, authCode.Subject)
+	} else {
+		s.subjectCodes[authCode.Subject] = codes
+	}
+	return authCode, true
+}
+
+// newCode returns a fresh authorization code from Config.CodeGenerator.
+func (s *Server) newCode() (string, error) {
+	if s.cfg.CodeGenerator == nil {
+		return s.randomString(32)
+	}
+	return s.cfg.CodeGenerator()
+}
+
+// newToken returns a fresh access token value from Config.TokenGenerator,
+// with Config.AccessTokenPrefix prepended.
+func (s *Server) newToken() (string, error) {
+	if s.cfg.TokenGenerator == nil {
+		id, err := uuid.NewRandomFromReader(s.random())
+		if err != nil {
+			return "", err
+		}
+		return s.cfg.AccessTokenPrefix + id.String(), nil
+	}
+	token, err := s.cfg.TokenGenerator()
+	if err != nil {
+		return "", err
+	}
+	return s.cfg.AccessTokenPrefix + token, nil
+}
+
+// random is the randomness source: Config.Rand, or crypto/rand.
+func (s *Server) random() io.Reader {
+	if s.cfg.Rand == nil {
+		return rand.Reader
+	}
+	return s.cfg.Rand
+}
+
+// randomString returns n random bytes, base64url encoded.
+func (s *Server) randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(s.random(), buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (s *Server) authenticateUser(r *http.Request) (string, bool) {
+	if s.cfg.AuthenticateUser == nil {
+		return "", false
+	}
+	return s.cfg.AuthenticateUser(r)
+}
+
+// subjectFor returns the sub a client gets to see for a local user id. For
+// pairwise clients it is BASE64URL(SHA256(sector || sub || salt)), as in
+// OIDC Core 8.1, so clients in different sectors can't correlate users.
+func (s *Server) subjectFor(client Client, subject string) string {
+	if client.SubjectType != SubjectTypePairwise {
+		return subject
+	}
+
+	sector := client.SectorIdentifier
+	if sector == "" {
+		if u, err := url.Parse(client.RedirectURI); err == nil {
+			sector = u.Host
+		}
+	}
+	hash := sha256.Sum256([]byte(sector + subject + s.cfg.PairwiseSalt))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (s *Server) defaultAudience(client Client) string {
+	if client.DefaultAudience != "" {
+		return client.DefaultAudience
+	}
+	if s.cfg.DefaultAudience != "" {
+		return s.cfg.DefaultAudience
+	}
+	return s.cfg.Issuer
+}
+
+func (s *Server) defaultScope(client Client) string {
+	if client.DefaultScope != "" {
+		return client.DefaultScope
+	}
+	return s.cfg.DefaultScope
+}
+
+// Parameters each endpoint understands, used by Config.StrictParams.
+var (
+	authorizeParams = []string{"response_type", "response_mode", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method", "organization", "org_id"}
+	tokenParams     = []string{"grant_type", "code", "redirect_uri", "code_verifier", "client_id", "client_secret", "client_assertion_type", "client_assertion", "assertion", "scope"}
+)
+
+// unknownParam returns the alphabetically first parameter in values that is
+// not in known, or "" when every parameter is recognized. Sorting keeps the
+// error the same for every request, whatever the map order.
+func unknownParam(values url.Values, known []string) string {
+	var unknown []string
+	for name := range values {
+		if !slices.Contains(known, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return ""
+	}
+	slices.Sort(unknown)
+	return unknown[0]
+}
+
+func jsonError(w http.ResponseWriter, err string, status int) {
+	oauthError(w, err, "", status)
+}
+
+// oauthError writes an RFC 6749 section 5.2 error response. The description
+// is optional and meant for client developers.
+func oauthError(w http.ResponseWriter, err string, description string, status int) {
+	body := map[string]string{
+		"error": err,
+	}
+	if description != "" {
+		body["error_description"] = description
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
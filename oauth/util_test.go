@@ -0,0 +1,105 @@
+package oauth
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer abc", "abc", true},
+		{"BEARER abc", "abc", true},
+		{"  Bearer abc  ", "abc", true},
+		{"Bearer    abc", "abc", true},
+		{"Bearer", "", false},
+		{"Bearer   ", "", false},
+		{"Basic abc", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/userinfo", nil)
+		r.Header.Set("Authorization", tt.header)
+		token, ok := bearerToken(r)
+		if token != tt.token || ok != tt.ok {
+			t.Errorf("bearerToken(%q) = %q, %v; want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// One byte changed at the end of each RFC 7636 appendix B value
+	badVerifier := testVerifier[:len(testVerifier)-1] + "l"
+	badChallenge := testChallenge[:len(testChallenge)-1] + "N"
+
+	tests := []struct {
+		method    string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{"S256", testChallenge, testVerifier, true},
+		{"S256", testChallenge, badVerifier, false},
+		{"S256", badChallenge, testVerifier, false},
+		{"S256", testChallenge, "", false},
+		{"plain", testVerifier, testVerifier, true},
+		{"plain", testVerifier, badVerifier, false},
+		{"plain", "", "", false},
+		{"S257", testChallenge, testVerifier, false},
+	}
+	for _, tt := range tests {
+		if got := verifyPKCE(tt.method, tt.challenge, tt.verifier); got != tt.want {
+			t.Errorf("verifyPKCE(%q, %q, %q) = %v, want %v", tt.method, tt.challenge, tt.verifier, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	s := NewServer(DefaultConfig())
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{"https://app.example.com/cb", false},
+		{"https://app.example.com/cb?tenant=1", false},
+		{"http://localhost:8080/cb", false},
+		{"http://127.0.0.1/cb", false},
+		{"http://[::1]:8080/cb", false},
+		{"https://app.example.com/cb#section", true},
+		{"https://app.example.com/cb#", true},
+		{"http://app.example.com/cb", true},
+		{"http://localhost.example.com/cb", true},
+		{"custom-scheme://cb", true},
+		{"/cb", true},
+	}
+	for _, tt := range tests {
+		if err := s.validateRedirectURI(tt.uri); (err != nil) != tt.wantErr {
+			t.Errorf("validateRedirectURI(%q) = %v, want error %v", tt.uri, err, tt.wantErr)
+		}
+	}
+
+	s.cfg.AllowedRedirectHosts = []string{"app.example.com"}
+	if err := s.validateRedirectURI("https://other.example.com/cb"); err == nil {
+		t.Error("host missing from AllowedRedirectHosts accepted")
+	}
+	if err := s.validateRedirectURI("https://app.example.com/cb"); err != nil {
+		t.Errorf("allowed host rejected: %v", err)
+	}
+}
+
+func TestUnknownParamIsDeterministic(t *testing.T) {
+	values := url.Values{"zeta": {"1"}, "client_id": {"x"}, "beta": {"2"}, "alpha": {"3"}, "gamma": {"4"}}
+	for i := 0; i < 50; i++ {
+		if got := unknownParam(values, authorizeParams); got != "alpha" {
+			t.Fatalf("unknownParam = %q, want alpha", got)
+		}
+	}
+	if got := unknownParam(url.Values{"client_id": {"x"}}, authorizeParams); got != "" {
+		t.Errorf("unknownParam with only known parameters = %q, want \"\"", got)
+	}
+}
@@ -0,0 +1,56 @@
+package oauth
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidToken     = errors.New("oauth: invalid token")
+	ErrTokenExpired     = errors.New("oauth: token expired")
+	ErrTokenNotYetValid = errors.New("oauth: token not yet valid")
+	ErrTokenRevoked     = errors.New("oauth: token revoked")
+	ErrClientDisabled   = errors.New("oauth: token issued to a disabled client")
+	ErrRegionMismatch   = errors.New("oauth: token issued for another region")
+)
+
+// ValidateToken looks up an opaque access token and checks that it is
+// currently valid. Resource servers embedding this package can call it
+// instead of reimplementing the checks done by /userinfo. With
+// Config.CheckClientEnabledOnUse, tokens of a disabled client are refused.
+func (s *Server) ValidateToken(token string) (*AccessToken, error) {
+	s.mu.Lock()
+	accessToken, exists := s.tokenStore[token]
+	client := s.clientStore[accessToken.ClientID]
+	s.mu.Unlock()
+
+	if !exists {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if now.After(accessToken.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if cutoff := s.tokensNotBefore.Load(); cutoff != 0 && accessToken.IssuedAt.UnixNano() < cutoff {
+		return nil, ErrTokenRevoked
+	}
+	if now.Before(accessToken.NotBefore) {
+		return nil, ErrTokenNotYetValid
+	}
+	if s.cfg.CheckClientEnabledOnUse && (client.ID == "" || client.Disabled) {
+		return nil, ErrClientDisabled
+	}
+	return &accessToken, nil
+}
+
+// CheckRegion lets a resource server refuse tokens meant for another
+// data-residency region: pass the token's region (AccessToken.Region or
+// Introspection.Region) and the region the server runs in. A token with
+// no region doesn't match any.
+func CheckRegion(tokenRegion string, serverRegion string) error {
+	if tokenRegion == "" || tokenRegion != serverRegion {
+		return ErrRegionMismatch
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrefixedTokensValidate(t *testing.T) {
+	srv, ts := newTestServer(t, func(cfg *Config) { cfg.AccessTokenPrefix = "at_" })
+
+	code := authorizeCode(t, ts, nil)
+	if strings.HasPrefix(code, "at_") {
+		t.Errorf("code %q carries the access token prefix", code)
+	}
+	resp, body := exchange(t, ts, code, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %d, body %v", resp.StatusCode, body)
+	}
+	token := body["access_token"].(string)
+	if !strings.HasPrefix(token, "at_") {
+		t.Fatalf("access token %q has no prefix", token)
+	}
+
+	if _, err := srv.ValidateToken(token); err != nil {
+		t.Errorf("ValidateToken(prefixed) = %v", err)
+	}
+	if _, err := srv.ValidateToken(strings.TrimPrefix(token, "at_")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken(unprefixed) = %v, want ErrInvalidToken", err)
+	}
+	if resp, _ := get(t, ts, "/userinfo", bearer(token)); resp.StatusCode != http.StatusOK {
+		t.Errorf("userinfo status = %d", resp.StatusCode)
+	}
+	if info := introspectAsDemo(t, ts, token); info["active"] != true {
+		t.Errorf("introspection = %v, want active", info)
+	}
+}
+
+func TestRegionClaim(t *testing.T) {
+	tests := []struct {
+		name          string
+		clientRegion  string
+		profileRegion string
+		want          string
+	}{
+		{"from the client", "eu", "", "eu"},
+		{"user's profile wins", "eu", "us", "us"},
+		{"none configured", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ts := newTestServer(t, func(cfg *Config) {
+				cfg.Profiles = StaticProfiles{testSubject: {Subject: testSubject, Region: tt.profileRegion}}
+			})
+			srv.RegisterClient(Client{ID: testClientID, RedirectURI: testRedirectURI, Secret: testSecret, Region: tt.clientRegion})
+
+			token := issueToken(t, ts)
+			accessToken, err := srv.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accessToken.Region != tt.want {
+				t.Errorf("Region = %q, want %q", accessToken.Region, tt.want)
+			}
+			if region, _ := introspectAsDemo(t, ts, token)["region"].(string); region != tt.want {
+				t.Errorf("introspected region = %q, want %q", region, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRegion(t *testing.T) {
+	tests := []struct {
+		token, server string
+		wantErr       bool
+	}{
+		{"eu", "eu", false},
+		{"eu", "us", true},
+		{"", "eu", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		err := CheckRegion(tt.token, tt.server)
+		if tt.wantErr && !errors.Is(err, ErrRegionMismatch) || !tt.wantErr && err != nil {
+			t.Errorf("CheckRegion(%q, %q) = %v, want error %v", tt.token, tt.server, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ==========================================
+// Webhooks
+// ==========================================
+
+// WebhookEvent is the JSON body POSTed to Config.WebhookURL. The payload is
+// signed with HMAC-SHA256 over the body, sent as "X-Webhook-Signature: sha256=<hex>".
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	ClientID  string    `json:"client_id"`
+	Subject   string    `json:"sub"` // masked
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// deliverWebhook is the event bus subscriber behind Config.WebhookURL. It
+// makes the first attempt on the subscriber's worker; retries are left to
+// attemptWebhook's timers, so a failing endpoint doesn't hold up the
+// events queued behind this one.
+func (s *Server) deliverWebhook(event Event) {
+	body, _ := json.Marshal(WebhookEvent{
+		Type:      event.Type,
+		ClientID:  event.ClientID,
+		Subject:   maskSubject(event.Subject),
+		Timestamp: event.Time,
+	})
+	s.attemptWebhook(event.Type, body, 1, s.cfg.WebhookRetryBackoff)
+}
+
+// attemptWebhook POSTs body and, if that fails, schedules the next attempt
+// after backoff, up to Config.WebhookMaxAttempts attempts in all.
+func (s *Server) attemptWebhook(eventType string, body []byte, attempt int, backoff time.Duration) {
+	err := s.postWebhook(body)
+	if err == nil {
+		return
+	}
+	if attempt >= s.cfg.WebhookMaxAttempts {
+		log.Printf("webhook %s failed after %d attempts: %v", eventType, attempt, err)
+		return
+	}
+	log.Printf("webhook %s attempt %d failed, retrying in %s: %v", eventType, attempt, backoff, err)
+	time.AfterFunc(backoff, func() {
+		s.attemptWebhook(eventType, body, attempt+1, backoff*2)
+	})
+}
+
+func (s *Server) postWebhook(body []byte) error {
+	mac := hmac.New(sha256.New, []byte(s.cfg.WebhookSecret))
+	mac.Write(body)
+
+	req, err := http.NewRequest("POST", s.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// maskSubject keeps only the first and last two characters of a subject.
+func maskSubject(subject string) string {
+	if len(subject) <= 4 {
+		return strings.Repeat("*", len(subject))
+	}
+	return subject[:2] + strings.Repeat("*", len(subject)-4) + subject[len(subject)-2:]
+}
@@ -0,0 +1,81 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type webhookDelivery struct {
+	body      []byte
+	signature string
+}
+
+func TestWebhookRetriesWithoutBlockingLaterEvents(t *testing.T) {
+	deliveries := make(chan webhookDelivery, 10)
+	var requests atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- webhookDelivery{body: body, signature: r.Header.Get("X-Webhook-Signature")}
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // only the first attempt fails
+		}
+	}))
+	defer receiver.Close()
+
+	_, ts := newTestServer(t, func(cfg *Config) {
+		cfg.WebhookURL = receiver.URL
+		cfg.WebhookSecret = "hook-secret"
+		cfg.WebhookRetryBackoff = 300 * time.Millisecond
+	})
+
+	next := func() webhookDelivery {
+		t.Helper()
+		select {
+		case delivery := <-deliveries:
+			mac := hmac.New(sha256.New, []byte("hook-secret"))
+			mac.Write(delivery.body)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); delivery.signature != want {
+				t.Errorf("X-Webhook-Signature = %q, want %q", delivery.signature, want)
+			}
+			return delivery
+		case <-time.After(2 * time.Second):
+			t.Fatal("no webhook delivery")
+			return webhookDelivery{}
+		}
+	}
+
+	issueToken(t, ts)
+	failed := next()
+	issueToken(t, ts)
+	second := next()
+	retried := next()
+
+	// The second event went out during the first one's backoff
+	if string(second.body) == string(failed.body) {
+		t.Error("second delivery repeats the failed event; the retry blocked the queue")
+	}
+	if string(retried.body) != string(failed.body) {
+		t.Errorf("retry body = %s, want the failed event %s", retried.body, failed.body)
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(retried.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventTokenIssued || event.ClientID != testClientID || event.Subject != maskSubject(testSubject) {
+		t.Errorf("event = %+v", event)
+	}
+	select {
+	case extra := <-deliveries:
+		t.Errorf("unexpected delivery after success: %s", extra.body)
+	case <-time.After(400 * time.Millisecond):
+	}
+}